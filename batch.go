@@ -0,0 +1,222 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBatchSize 是 Server.MaxBatchSize 为 0 时单个批量调用允许的最大调用数
+const DefaultMaxBatchSize = 1024
+
+// batchConcurrency 是一个批量调用中同时执行的最大调用数
+const batchConcurrency = 16
+
+// batchEntry 是批量调用中的一个调用。批量调用的消息体是 []batchEntry，
+// 每个调用的参数先用连接的编解码类型单独编码，服务器找到方法后才能按参数类型解码
+type batchEntry struct {
+	ServiceMethod string
+	Args          []byte
+}
+
+// batchResult 是批量调用中一个调用的结果，批量调用的响应体是与请求一一对应的 []batchResult
+type batchResult struct {
+	Error    string
+	Code     uint32
+	Details  map[string]string
+	Metadata map[string]string // 服务方法设置的响应元数据
+	Reply    []byte            // 用连接的编解码类型编码的返回值，出错时为空
+}
+
+// batchError 返回表示 err 的结果
+func batchError(err error, code Code) batchResult {
+	var h codec.Header
+	setError(&h, err, code)
+	return batchResult{Error: h.Error, Code: h.Code, Details: h.Details}
+}
+
+// readBatch 读取批量调用的请求体。找不到方法或参数无法解码的调用直接在 results 中记录错误，不影响其他调用；
+// 返回的错误表示整个批量调用失败
+func (server *Server) readBatch(sc *serverConn, h *codec.Header) (*request, error) {
+	req := &request{h: h}
+	if h.Timeout != 0 {
+		req.deadline = time.Now().Add(time.Duration(h.Timeout))
+	}
+	var entries []batchEntry
+	if err := sc.cc.ReadBody(&entries); err != nil {
+		return req, bodyError(err)
+	}
+	if sc.marshaler == nil {
+		return req, Errorf(CodeInvalidArgument, "rpc server: codec of the connection does not support batch calls")
+	}
+	if max := server.maxBatchSize(); len(entries) > max {
+		return req, Errorf(CodeResourceExhausted, "rpc server: batch of %d calls exceeds limit of %d", len(entries), max)
+	}
+	req.batch = make([]*request, len(entries))
+	req.results = make([]batchResult, len(entries))
+	for i, e := range entries {
+		sub, err := server.newBatchRequest(sc, req, e)
+		if err != nil {
+			req.results[i] = batchError(err, CodeUnknown)
+			continue
+		}
+		req.batch[i] = sub
+	}
+	return req, nil
+}
+
+func (server *Server) maxBatchSize() int {
+	if server.MaxBatchSize > 0 {
+		return server.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
+
+// newBatchRequest 创建批量调用中的一个调用，它共享批量调用的 Seq、请求元数据和截止时间
+func (server *Server) newBatchRequest(sc *serverConn, batch *request, e batchEntry) (*request, error) {
+	var err error
+	req := &request{
+		h:        &codec.Header{ServiceMethod: e.ServiceMethod, Seq: batch.h.Seq, Metadata: batch.h.Metadata},
+		deadline: batch.deadline,
+	}
+	req.svc, req.mtype, err = server.findService(e.ServiceMethod)
+	if err != nil {
+		return nil, err
+	}
+	if req.mtype.Stream {
+		return nil, Errorf(CodeInvalidArgument, "rpc server: streaming method %s can't be called in a batch", e.ServiceMethod)
+	}
+	req.argv = req.mtype.newArgv()
+	req.replyv = req.mtype.newReplyv()
+	if err = sc.marshaler.Unmarshal(e.Args, req.argvPtr()); err != nil {
+		return nil, Errorf(CodeInvalidArgument, "rpc server: reading args of %s: %s", e.ServiceMethod, err)
+	}
+	return req, nil
+}
+
+// handleBatch 并发执行批量调用中的所有调用，全部完成后一起发送结果，同时执行的调用不超过 batchConcurrency 个。
+// 每个执行调用的 Goroutine 占用一个名额：第一个使用批量调用本身的名额，其余的只在连接和服务器都有空闲名额时启动，
+// 名额不足时调用依次执行，因此批量调用不会越过 MaxConnInflight 和 MaxInflight。客户端按 Seq 取消时所有调用一起被取消。
+// 超时的调用在返回之前继续占用执行它的 Goroutine，名额在调用真正返回后才释放
+func (server *Server) handleBatch(ctx context.Context, sc *serverConn, req *request, timeout time.Duration) {
+	defer sc.end()
+	defer server.release(sc)
+	defer sc.untrack(req.h.Seq)
+
+	jobs := make(chan int)
+	var results, running sync.WaitGroup
+	for n := 0; n < min(batchConcurrency, len(req.batch)); n++ {
+		if n > 0 && !server.tryAcquire(sc) {
+			break
+		}
+		running.Add(1)
+		go func(extra bool) {
+			defer running.Done()
+			if extra {
+				defer server.release(sc)
+			}
+			for i := range jobs {
+				sub := req.batch[i]
+				body, ok, done := server.runRequest(ctx, sub, timeout)
+				if ok {
+					req.results[i] = sc.batchResult(sub.h, body)
+				}
+				results.Done()
+				<-done
+			}
+		}(n > 0)
+	}
+dispatch:
+	for i, sub := range req.batch {
+		if sub == nil {
+			continue
+		}
+		results.Add(1)
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			results.Done()
+			break dispatch // 批量调用已被取消，剩下的调用不再执行
+		}
+	}
+	close(jobs)
+	results.Wait()
+	if cause := context.Cause(ctx); cause != errConnClosed && cause != errCallCanceled {
+		// 连接已关闭或客户端已经放弃了调用时不再发送响应
+		req.h.Metadata = nil
+		server.respond(sc, req, req.results)
+	}
+	running.Wait()
+}
+
+// batchResult 把一个调用的响应头和返回值转换为结果
+func (sc *serverConn) batchResult(h *codec.Header, body interface{}) batchResult {
+	if h.Error != "" {
+		return batchResult{Error: h.Error, Code: h.Code, Details: h.Details, Metadata: h.Metadata}
+	}
+	reply, err := sc.marshaler.Marshal(body)
+	if err != nil {
+		return batchError(fmt.Errorf("rpc server: encoding reply of %s: %w", h.ServiceMethod, err), CodeInternal)
+	}
+	return batchResult{Metadata: h.Metadata, Reply: reply}
+}
+
+// Batch 在一个请求中发送 calls 中的所有调用，服务器并发执行它们，全部完成后在一个响应中返回结果。
+// 调用数超过服务器的 MaxBatchSize 时整个批量调用以 CodeResourceExhausted 失败。
+// 每个调用只需要设置 ServiceMethod、Args 和 Reply，各自的错误和响应元数据写入 Error 和 ReplyMetadata。
+// 返回的错误表示整个批量调用失败，例如连接断开或 ctx 结束，此时各个调用的结果没有意义。
+// ctx 中的请求元数据和截止时间对所有调用生效，流式方法不能批量调用
+func (client *Client) Batch(ctx context.Context, calls []*Call) error {
+	if client.handshake == nil || client.handshake.Version < 7 {
+		return errors.New("rpc client: server does not support batch calls")
+	}
+	m := codec.MarshalerMap[client.opt.CodecType]
+	if m == nil {
+		return fmt.Errorf("rpc client: codec type %s does not support batch calls", client.opt.CodecType)
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+	entries := make([]batchEntry, len(calls))
+	for i, call := range calls {
+		args, err := m.Marshal(call.Args)
+		if err != nil {
+			return fmt.Errorf("rpc client: encoding args of %s: %w", call.ServiceMethod, err)
+		}
+		entries[i] = batchEntry{ServiceMethod: call.ServiceMethod, Args: args}
+	}
+
+	var results []batchResult
+	md, _ := FromOutgoingContext(ctx)
+	deadline, _ := ctx.Deadline()
+	batch := &Call{
+		Args:     entries,
+		Reply:    &results,
+		Metadata: md,
+		Deadline: deadline,
+		Done:     make(chan *Call, 1),
+		kind:     codec.KindBatch,
+	}
+	client.send(batch)
+	if err := client.wait(ctx, batch); err != nil {
+		return err
+	}
+	if len(results) != len(calls) {
+		return fmt.Errorf("rpc client: batch of %d calls got %d results", len(calls), len(results))
+	}
+	for i, r := range results {
+		call := calls[i]
+		call.ReplyMetadata = r.Metadata
+		if r.Error != "" {
+			call.Error = errorFromHeader(&codec.Header{Error: r.Error, Code: r.Code, Details: r.Details})
+			continue
+		}
+		if err := m.Unmarshal(r.Reply, call.Reply); err != nil {
+			call.Error = &Error{Code: CodeInternal, Message: "rpc client: reading reply " + err.Error(), cause: err}
+		}
+	}
+	return nil
+}
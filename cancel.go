@@ -0,0 +1,47 @@
+package test
+
+import "context"
+
+// errCallCanceled 是客户端取消调用时取消请求 context 的原因
+var errCallCanceled = Errorf(CodeCanceled, "rpc server: call canceled by client")
+
+// serverCall 是一个正在处理的请求，客户端可以按 Seq 取消它，流式调用还会收到客户端的流控消息
+type serverCall struct {
+	cancel context.CancelCauseFunc
+	stream *ServerStream // 非流式调用时为 nil
+}
+
+// track 记录一个正在处理的请求，收到取消消息时通过 cancel 取消它
+func (sc *serverConn) track(seq uint64, cancel context.CancelCauseFunc, stream *ServerStream) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.calls == nil {
+		sc.calls = make(map[uint64]*serverCall)
+	}
+	sc.calls[seq] = &serverCall{cancel: cancel, stream: stream}
+}
+
+// untrack 在请求处理完成后移除它并释放它的 context
+func (sc *serverConn) untrack(seq uint64) {
+	sc.mu.Lock()
+	call := sc.calls[seq]
+	delete(sc.calls, seq)
+	sc.mu.Unlock()
+	if call != nil {
+		call.cancel(nil)
+	}
+}
+
+// lookup 返回 seq 对应的正在处理的请求，请求已经处理完成时返回 nil
+func (sc *serverConn) lookup(seq uint64) *serverCall {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.calls[seq]
+}
+
+// cancel 取消 seq 对应的请求，请求已经处理完成时什么也不做
+func (sc *serverConn) cancel(seq uint64) {
+	if call := sc.lookup(seq); call != nil {
+		call.cancel(errCallCanceled)
+	}
+}
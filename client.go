@@ -23,10 +23,18 @@ type Call struct {
 	Args          interface{} // arguments to the function
 	Reply         interface{} // reply from the function
 	Error         error       // if error occurs, it will be set
+	Metadata      Metadata    // metadata sent with the request
+	Deadline      time.Time   // if not zero, the server stops handling the call at this time
+	ReplyMetadata Metadata    // metadata returned with the response
 	Done          chan *Call  // Strobes when call is complete.
+	stream        *ClientStream
+	kind          codec.Kind // KindCall, or KindBatch for the request carrying a batch
 }
 
 func (call *Call) done() {
+	if call.stream != nil {
+		close(call.stream.finished)
+	}
 	call.Done <- call
 }
 
@@ -35,15 +43,20 @@ func (call *Call) done() {
 // with a single Client, and a Client may be used by
 // multiple goroutines simultaneously.
 type Client struct {
-	cc       codec.Codec
-	opt      *Option
-	sending  sync.Mutex // protect following
-	header   codec.Header
-	mu       sync.Mutex // protect following
-	seq      uint64
-	pending  map[uint64]*Call
-	closing  bool // user has called Close
-	shutdown bool // server has told us to stop
+	cc        codec.Codec
+	opt       *Option
+	handshake *Handshake // server acknowledgement, nil for legacy protocol
+	sending   sync.Mutex // protect following
+	header    codec.Header
+	mu        sync.Mutex // protect following
+	seq       uint64
+	pending   map[uint64]*Call
+	closing   bool // user has called Close
+	shutdown  bool // server has told us to stop
+	draining  bool // server is shutting down and accepts no new calls
+	pong      chan struct{}
+	done      chan struct{} // closed when receive returns
+	services  sync.Map      // services registered for the server to call back into
 }
 
 var _ io.Closer = (*Client)(nil)
@@ -65,7 +78,14 @@ func (client *Client) Close() error {
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.draining
+}
+
+// isDraining reports whether the server has announced its shutdown.
+func (client *Client) isDraining() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.draining
 }
 
 func (client *Client) registerCall(call *Call) (uint64, error) {
@@ -74,12 +94,31 @@ func (client *Client) registerCall(call *Call) (uint64, error) {
 	if client.closing || client.shutdown {
 		return 0, ErrShutdown
 	}
+	if client.draining {
+		return 0, errServerShutdown
+	}
 	call.Seq = client.seq
 	client.pending[call.Seq] = call
 	client.seq++
 	return call.Seq, nil
 }
 
+// reserveSeq allocates a sequence number for a request that gets no reply,
+// nothing is added to pending.
+func (client *Client) reserveSeq() (uint64, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.closing || client.shutdown {
+		return 0, ErrShutdown
+	}
+	if client.draining {
+		return 0, errServerShutdown
+	}
+	seq := client.seq
+	client.seq++
+	return seq, nil
+}
+
 func (client *Client) removeCall(seq uint64) *Call {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -94,8 +133,10 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
-		call.Error = err
+	for seq, call := range client.pending {
+		// the connection is gone, the call may or may not have been executed
+		delete(client.pending, seq)
+		call.Error = &Error{Code: CodeUnavailable, Message: err.Error(), cause: err}
 		call.done()
 	}
 }
@@ -105,6 +146,17 @@ func (client *Client) send(call *Call) {
 	client.sending.Lock()
 	defer client.sending.Unlock()
 
+	// the remaining time is measured after waiting for the lock,
+	// a call that has already expired is not sent at all
+	var timeout time.Duration
+	if !call.Deadline.IsZero() {
+		if timeout = time.Until(call.Deadline); timeout <= 0 {
+			call.Error = &Error{Code: CodeDeadlineExceeded, Message: "rpc client: call deadline exceeded before sending", cause: context.DeadlineExceeded}
+			call.done()
+			return
+		}
+	}
+
 	// register this call.
 	seq, err := client.registerCall(call)
 	if err != nil {
@@ -114,9 +166,16 @@ func (client *Client) send(call *Call) {
 	}
 
 	// prepare request header
+	client.header.Kind = call.kind
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.Metadata = call.Metadata
+	client.header.Timeout = int64(timeout)
+	client.header.Window = 0
+	if call.stream != nil {
+		client.header.Window = call.stream.recv.window
+	}
 
 	// encode and send the request
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
@@ -130,6 +189,95 @@ func (client *Client) send(call *Call) {
 	}
 }
 
+// OneWay invokes the named function without waiting for it: the server runs
+// the method but sends no reply, so OneWay returns as soon as the request
+// has been written. Failures on the server, including an unknown method,
+// are only logged there. Metadata and the deadline of ctx are sent as for Call.
+func (client *Client) OneWay(ctx context.Context, serviceMethod string, args interface{}) error {
+	if client.handshake == nil || client.handshake.Version < 6 {
+		return errors.New("rpc client: server does not support one-way calls")
+	}
+	md, _ := FromOutgoingContext(ctx)
+	deadline, hasDeadline := ctx.Deadline()
+
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	var timeout time.Duration
+	if hasDeadline {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return &Error{Code: CodeDeadlineExceeded, Message: "rpc client: call deadline exceeded before sending", cause: context.DeadlineExceeded}
+		}
+	}
+	seq, err := client.reserveSeq()
+	if err != nil {
+		return err
+	}
+	client.header.Kind = codec.KindOneWay
+	client.header.ServiceMethod = serviceMethod
+	client.header.Seq = seq
+	client.header.Error = ""
+	client.header.Metadata = md
+	client.header.Timeout = int64(timeout)
+	client.header.Window = 0
+	return client.cc.Write(&client.header, args)
+}
+
+// cancel tells the server that the call with the given seq has been abandoned.
+// Servers before protocol version 2 do not understand the message, nothing is sent to them.
+func (client *Client) cancel(seq uint64) {
+	if client.handshake == nil || client.handshake.Version < 2 {
+		return
+	}
+	client.writeControl(&codec.Header{Kind: codec.KindCancel, Seq: seq})
+}
+
+// writeControl sends a message that carries no body.
+func (client *Client) writeControl(h *codec.Header) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	_ = client.cc.Write(h, invalidRequest)
+}
+
+// handleControl handles a message from the server that is not a reply.
+func (client *Client) handleControl(h *codec.Header) {
+	switch h.Kind {
+	case codec.KindGoAway:
+		// pending calls still get their replies, new calls are refused
+		client.mu.Lock()
+		client.draining = true
+		client.mu.Unlock()
+	case codec.KindPing:
+		// receive must never block on sending
+		go client.writeControl(&codec.Header{Kind: codec.KindPong})
+	case codec.KindPong:
+		select {
+		case client.pong <- struct{}{}:
+		default:
+		}
+	case codec.KindWindowUpdate:
+		client.mu.Lock()
+		call := client.pending[h.Seq]
+		client.mu.Unlock()
+		if call != nil && call.stream != nil {
+			call.stream.send.add(h.Window)
+		}
+	}
+}
+
+// keepalive pings the server and closes the connection when pongs stop,
+// after which IsAvailable reports false.
+func (client *Client) keepalive() {
+	ping := func() { client.writeControl(&codec.Header{Kind: codec.KindPing}) }
+	if pingLoop(client.done, client.pong, ping, client.opt.KeepaliveInterval, client.opt.KeepaliveTimeout) {
+		return
+	}
+	log.Println("rpc client: keepalive timeout, closing connection")
+	client.mu.Lock()
+	client.shutdown = true
+	client.mu.Unlock()
+	_ = client.cc.Close()
+}
+
 func (client *Client) receive() {
 	var err error
 	for err == nil {
@@ -137,26 +285,55 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		if h.Kind == codec.KindStreamMsg {
+			err = client.receiveStream(&h)
+			continue
+		}
+		if h.Kind == codec.KindReverseCall {
+			err = client.receiveCall(&h)
+			continue
+		}
+		if h.Kind != codec.KindCall && h.Kind != codec.KindBatch {
+			client.handleControl(&h)
+			err = client.cc.ReadBody(nil)
+			continue
+		}
 		call := client.removeCall(h.Seq)
+		if call != nil {
+			call.ReplyMetadata = h.Metadata
+		}
 		switch {
 		case call == nil:
 			// it usually means that Write partially failed
 			// and call was already removed.
 			err = client.cc.ReadBody(nil)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+			call.Error = errorFromHeader(&h)
 			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+				if errors.Is(err, codec.ErrMessageTooLarge) {
+					call.Error = &Error{Code: CodeResourceExhausted, Message: "reading body " + err.Error(), cause: err}
+				} else {
+					call.Error = errors.New("reading body " + err.Error())
+				}
+				// with framing, a body that fails to decode does not affect other calls
+				if isDecodeError(err) {
+					err = nil
+				}
 			}
 			call.done()
 		}
 	}
 	// error occurs, so terminateCalls pending calls
 	client.terminateCalls(err)
+	close(client.done)
+	if client.isDraining() {
+		// the server closed a drained connection, nobody else may close it
+		_ = client.cc.Close()
+	}
 }
 
 // Go invokes the function asynchronously.
@@ -179,13 +356,38 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
+// Metadata attached with NewOutgoingContext is sent with the request, and
+// the reply metadata is stored into the target given to WithReplyMetadata.
+// The deadline of ctx is sent as well, so the server gives up at the same time;
+// a service calling on with the ctx it was handed inherits the remaining time.
 func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	md, _ := FromOutgoingContext(ctx)
+	deadline, _ := ctx.Deadline()
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Metadata:      md,
+		Deadline:      deadline,
+		Done:          make(chan *Call, 1),
+	}
+	client.send(call)
+	return client.wait(ctx, call)
+}
+
+// wait waits for a sent call to complete, or abandons it when ctx is done.
+func (client *Client) wait(ctx context.Context, call *Call) error {
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
-		return errors.New("rpc client: call failed: " + ctx.Err().Error())
+		if client.removeCall(call.Seq) != nil {
+			// the server is still working on it, ask it to stop
+			client.cancel(call.Seq)
+		}
+		return &Error{Code: contextCode(ctx.Err()), Message: "rpc client: call failed: " + ctx.Err().Error(), cause: ctx.Err()}
 	case call := <-call.Done:
+		if p, ok := ctx.Value(replyCaptureKey{}).(*Metadata); ok {
+			*p = call.ReplyMetadata
+		}
 		return call.Error
 	}
 }
@@ -200,6 +402,9 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	}
 	opt := opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
+	if opt.Version == 0 {
+		opt.Version = DefaultOption.Version
+	}
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
@@ -207,9 +412,11 @@ func parseOptions(opts ...*Option) (*Option, error) {
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
+	if opt.Version == 0 {
+		opt.Version = ProtocolVersion
+	}
+	f, err := newCodecFunc(opt)
+	if err != nil {
 		log.Println("rpc client: codec error:", err)
 		return nil, err
 	}
@@ -219,7 +426,23 @@ func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	if opt.Version < 1 {
+		// legacy protocol: the server does not acknowledge options
+		return newClientCodec(limitCodec(f(conn), opt.MaxResponseSize), opt), nil
+	}
+	// wait for the server to accept the options before sending any request
+	ack, rwc, err := readHandshake(conn, opt)
+	if err != nil {
+		log.Println("rpc client: handshake error:", err)
+		_ = conn.Close()
+		return nil, err
+	}
+	client := newClientCodec(limitCodec(f(rwc), opt.MaxResponseSize), opt)
+	client.handshake = ack
+	if opt.KeepaliveInterval > 0 && ack.Version >= 3 {
+		go client.keepalive()
+	}
+	return client, nil
 }
 
 func newClientCodec(cc codec.Codec, opt *Option) *Client {
@@ -228,6 +451,8 @@ func newClientCodec(cc codec.Codec, opt *Option) *Client {
 		cc:      cc,
 		opt:     opt,
 		pending: make(map[uint64]*Call),
+		pong:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
 	}
 	go client.receive()
 	return client
@@ -302,7 +527,7 @@ func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
 // XDial calls different functions to connect to a RPC server
 // according the first parameter rpcAddr.
 // rpcAddr is a general format (protocol@addr) to represent a rpc server
-// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock
+// eg, http@10.0.0.1:7001, tcp@10.0.0.1:9999, unix@/tmp/geerpc.sock, tls@10.0.0.1:9443
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
@@ -312,6 +537,9 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp", addr, opts...)
+	case "tls":
+		// the TLS config is taken from Option.TLSConfig
+		return DialTLS("tcp", addr, nil, opts...)
 	default:
 		// tcp, unix or other transport protocol
 		return Dial(protocol, addr, opts...)
@@ -10,8 +10,32 @@ type Header struct {
 	ServiceMethod string // 格式为 "Service.Method"
 	Seq           uint64 // 客户端选择的序列号
 	Error         string
+	Code          uint32            // 错误码，0 表示成功
+	Details       map[string]string // 可选的错误详情
+	Metadata      map[string]string // 随请求或响应传递的元数据
+	Kind          Kind              // 消息类型，零值表示普通的请求或响应
+	Timeout       int64             // 请求剩余的超时时间，单位纳秒，0 表示没有截止时间
+	Window        uint32            // 流式调用中允许对端继续发送的消息数
 }
 
+// Kind 定义了消息的类型，除普通调用外的消息用于连接的控制
+type Kind uint8
+
+const (
+	KindCall         Kind = iota // 普通的请求或响应
+	KindGoAway                   // 服务器正在关闭，不再接受新的请求，Seq 为 0
+	KindCancel                   // 客户端放弃了 Seq 对应的调用，服务器取消它的执行且不再响应
+	KindPing                     // 保活探测，收到的一方回复 KindPong，Seq 为 0
+	KindPong                     // 对 KindPing 的回复，Seq 为 0
+	KindStreamMsg                // 流式调用中的一条消息，流以一个普通的响应结束
+	KindWindowUpdate             // 接收方读取了 Window 条消息，发送方可以继续发送这么多条
+	KindCloseSend                // 客户端不再向流中发送消息
+	KindOneWay                   // 单向调用的请求，服务器执行方法但不发送响应
+	KindBatch                    // 批量调用的请求或响应，一个消息体中包含多个调用
+	KindReverseCall              // 服务器向客户端发起的请求，Seq 由服务器分配，与客户端请求的 Seq 无关
+	KindReverseReply             // 客户端对 KindReverseCall 的响应
+)
+
 // Codec 接口定义了编解码器的行为
 type Codec interface {
 	io.Closer
@@ -28,17 +52,28 @@ type Type string
 
 // 定义一些常量，用于表示不同的编解码器类型
 const (
-	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // 未实现
+	GobType     Type = "application/gob"
+	JsonType    Type = "application/json"
+	MsgpackType Type = "application/msgpack"
 )
 
 // NewCodecFuncMap 是一个映射，用于存储不同类型的新编解码器函数
 var NewCodecFuncMap map[Type]NewCodecFunc
 
+// MarshalerMap 存储支持帧格式的编解码器类型对应的 Marshaler
+var MarshalerMap map[Type]Marshaler
+
 // init 函数在包加载时执行，用于初始化 NewCodecFuncMap
 func init() {
 	// 初始化 NewCodecFuncMap
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	// 将 GobType 的新编解码器函数添加到 NewCodecFuncMap 中
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
+	NewCodecFuncMap[MsgpackType] = NewMsgpackCodec
+
+	MarshalerMap = make(map[Type]Marshaler)
+	MarshalerMap[GobType] = gobMarshaler{}
+	MarshalerMap[JsonType] = jsonMarshaler{}
+	MarshalerMap[MsgpackType] = msgpackMarshaler{}
 }
@@ -0,0 +1,222 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testArgs struct {
+	Num1, Num2 int
+	Name       string
+	Tags       []string
+	Attrs      map[string]int
+}
+
+// roundTrip 通过一对内存连接，用同一种编解码器写入并读回两条消息，第一条的消息体被丢弃
+func roundTrip(t *testing.T, typ Type, h *Header, body interface{}, reply interface{}) *Header {
+	t.Helper()
+	f := NewCodecFuncMap[typ]
+	c1, c2 := net.Pipe()
+	w, r := f(c1), f(c2)
+	defer func() { _ = w.Close(); _ = r.Close() }()
+	errc := make(chan error, 1)
+	go func() {
+		if err := w.Write(&Header{ServiceMethod: "Foo.Skip", Seq: 1}, body); err != nil {
+			errc <- err
+			return
+		}
+		errc <- w.Write(h, body)
+	}()
+	var skipped Header
+	if err := r.ReadHeader(&skipped); err != nil || skipped.Seq != 1 {
+		t.Fatalf("%s: read first header: %v %+v", typ, err, skipped)
+	}
+	if err := r.ReadBody(nil); err != nil {
+		t.Fatalf("%s: discard body: %v", typ, err)
+	}
+	var got Header
+	if err := r.ReadHeader(&got); err != nil {
+		t.Fatalf("%s: read header: %v", typ, err)
+	}
+	if err := r.ReadBody(reply); err != nil {
+		t.Fatalf("%s: read body: %v", typ, err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("%s: write: %v", typ, err)
+	}
+	return &got
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	args := &testArgs{Num1: 1, Num2: -3, Name: "gee", Tags: []string{"a", "b"}, Attrs: map[string]int{"x": 1}}
+	for typ := range NewCodecFuncMap {
+		h := &Header{ServiceMethod: "Foo.Sum", Seq: 42, Error: "boom", Metadata: map[string]string{"trace-id": "t-1"}}
+		var reply testArgs
+		got := roundTrip(t, typ, h, args, &reply)
+		if !reflect.DeepEqual(got, h) {
+			t.Fatalf("%s: header mismatch: got %+v, want %+v", typ, got, h)
+		}
+		if !reflect.DeepEqual(&reply, args) {
+			t.Fatalf("%s: body mismatch: got %+v, want %+v", typ, reply, args)
+		}
+	}
+}
+
+type msgpackValue struct {
+	Small, Neg, Big int64
+	Huge            uint64
+	Ratio           float64
+	Ptr             *testArgs
+	Nil             *testArgs
+	Any             interface{}
+	Raw             []byte
+	When            time.Time
+	Renamed         string `msgpack:"r"`
+	Ignored         string `msgpack:"-"`
+}
+
+func TestMsgpackValues(t *testing.T) {
+	in := &msgpackValue{
+		Small: 5, Neg: -200, Big: 1 << 40, Huge: math.MaxUint64, Ratio: 0.25,
+		Ptr:     &testArgs{Num1: 7, Tags: []string{"x"}},
+		Any:     map[string]interface{}{"k": []interface{}{int64(1), "two", nil}},
+		Raw:     []byte{0, 1, 2},
+		When:    time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC),
+		Renamed: "renamed",
+		Ignored: "ignored",
+	}
+	var out msgpackValue
+	roundTrip(t, MsgpackType, &Header{ServiceMethod: "Foo.Sum", Seq: 1}, in, &out)
+	in.Ignored = ""
+	if !reflect.DeepEqual(&out, in) {
+		t.Fatalf("msgpack mismatch:\n got %+v\nwant %+v", out, *in)
+	}
+}
+
+func TestCompressedMarshaler(t *testing.T) {
+	big := &testArgs{Name: strings.Repeat("gee", 1000)}
+	small := &testArgs{Name: "gee"}
+	for name, c := range CompressorMap {
+		m := NewCompressedMarshaler(jsonMarshaler{}, c, 0)
+		for _, in := range []*testArgs{big, small} {
+			data, err := m.Marshal(in)
+			if err != nil {
+				t.Fatalf("%s: marshal: %v", name, err)
+			}
+			raw, _ := json.Marshal(in)
+			compressed := len(raw) >= DefaultCompressThreshold
+			if compressed != (data[0] == frameCompressed) || (compressed && len(data) >= len(raw)) {
+				t.Fatalf("%s: unexpected frame flag %d for %d bytes", name, data[0], len(raw))
+			}
+			var out testArgs
+			if err = m.Unmarshal(data, &out); err != nil || out.Name != in.Name {
+				t.Fatalf("%s: unmarshal: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	small := &testArgs{Name: "gee"}
+	big := &testArgs{Name: strings.Repeat("gee", 1000)}
+	newFuncs := make(map[string]NewCodecFunc)
+	for typ, f := range NewCodecFuncMap {
+		newFuncs[string(typ)] = f
+	}
+	for typ, m := range MarshalerMap {
+		m := NewCompressedMarshaler(m, CompressorMap[CompressGzip], 0)
+		newFuncs[string(typ)+"+gzip"] = func(conn io.ReadWriteCloser) Codec { return NewFramedCodec(conn, m) }
+	}
+	for name, f := range newFuncs {
+		c1, c2 := net.Pipe()
+		w, r := f(c1), f(c2)
+		r.(SizeLimiter).SetMaxMessageSize(256)
+		go func() {
+			for _, body := range []*testArgs{small, small, big} {
+				if w.Write(&Header{ServiceMethod: "Foo.Sum"}, body) != nil {
+					return
+				}
+			}
+		}()
+		for i := 0; i < 2; i++ {
+			var h Header
+			var out testArgs
+			if err := r.ReadHeader(&h); err != nil {
+				t.Fatalf("%s: read header %d: %v", name, i, err)
+			}
+			if err := r.ReadBody(&out); err != nil || out.Name != small.Name {
+				t.Fatalf("%s: read body %d: %v", name, i, err)
+			}
+		}
+		var h Header
+		if err := r.ReadHeader(&h); err != nil {
+			t.Fatalf("%s: read header: %v", name, err)
+		}
+		if err := r.ReadBody(new(testArgs)); !errors.Is(err, ErrMessageTooLarge) {
+			t.Fatalf("%s: expect ErrMessageTooLarge, got %v", name, err)
+		}
+		_ = w.Close()
+		_ = r.Close()
+	}
+}
+
+// TestForgedFrameLength 检查伪造的长度前缀不会让帧编解码器按前缀一次性分配内存
+func TestForgedFrameLength(t *testing.T) {
+	for typ, m := range MarshalerMap {
+		c1, c2 := net.Pipe()
+		r := NewFramedCodec(c2, m)
+		go func() {
+			_, _ = c1.Write([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+			_ = c1.Close()
+		}()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := r.ReadHeader(new(Header))
+		runtime.ReadMemStats(&after)
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("%s: expect io.ErrUnexpectedEOF, got %v", typ, err)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Fatalf("%s: reading a truncated frame allocated %d bytes", typ, n)
+		}
+		_ = r.Close()
+	}
+}
+
+type nestedList []nestedList
+
+// TestMsgpackDepth 检查深层嵌套的值返回错误，而不是耗尽解码 Goroutine 的栈
+func TestMsgpackDepth(t *testing.T) {
+	deep := func(prefix ...byte) []byte {
+		data := append(prefix, bytes.Repeat([]byte{0x91}, 1<<20)...)
+		return append(data, 0xc0)
+	}
+	m := MarshalerMap[MsgpackType]
+	cases := map[string]struct {
+		data []byte
+		v    interface{}
+	}{
+		"skip":    {deep(0x81, 0xa7, 'U', 'n', 'k', 'n', 'o', 'w', 'n'), new(testArgs)},
+		"discard": {deep(), nil},
+		"generic": {deep(), new(interface{})},
+		"typed":   {deep(), new(nestedList)},
+	}
+	for name, c := range cases {
+		if err := m.Unmarshal(c.data, c.v); err == nil || !strings.Contains(err.Error(), "nesting") {
+			t.Fatalf("%s: expect nesting error, got %v", name, err)
+		}
+	}
+	var ok nestedList
+	if err := m.Unmarshal([]byte{0x91, 0x91, 0x90}, &ok); err != nil || len(ok) != 1 || len(ok[0]) != 1 {
+		t.Fatalf("shallow nesting: %v %v", ok, err)
+	}
+}
@@ -0,0 +1,151 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"sync"
+)
+
+// Compression 定义了消息压缩算法的名称
+type Compression string
+
+const (
+	CompressNone  Compression = ""
+	CompressGzip  Compression = "gzip"
+	CompressFlate Compression = "flate" // 使用 flate.BestSpeed，速度优先
+)
+
+// DefaultCompressThreshold 是默认的压缩阈值，小于该字节数的消息原样发送
+const DefaultCompressThreshold = 1024
+
+// Compressor 接口定义了压缩算法的行为
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	// Decompress 解压 data，max 大于 0 时解压后的数据超过 max 字节返回 ErrMessageTooLarge
+	Decompress(data []byte, max int) ([]byte, error)
+}
+
+// CompressorMap 存储所有可用的压缩算法
+var CompressorMap = map[Compression]Compressor{
+	CompressGzip:  &gzipCompressor{},
+	CompressFlate: &flateCompressor{},
+}
+
+type gzipCompressor struct {
+	writers sync.Pool
+}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte, max int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return readAllLimit(r, max)
+}
+
+type flateCompressor struct {
+	writers sync.Pool
+}
+
+func (c *flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := c.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	}
+	defer c.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *flateCompressor) Decompress(data []byte, max int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer func() { _ = r.Close() }()
+	return readAllLimit(r, max)
+}
+
+// 每一帧的第一个字节标记内容是否被压缩
+const (
+	frameRaw        byte = 0
+	frameCompressed byte = 1
+)
+
+// compressedMarshaler 在 Marshaler 的基础上压缩超过阈值的数据
+type compressedMarshaler struct {
+	m         Marshaler
+	c         Compressor
+	threshold int
+}
+
+// NewCompressedMarshaler 用压缩算法 c 包装 m，threshold 为 0 时使用 DefaultCompressThreshold
+func NewCompressedMarshaler(m Marshaler, c Compressor, threshold int) Marshaler {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	return &compressedMarshaler{m: m, c: c, threshold: threshold}
+}
+
+func (cm *compressedMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := cm.m.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < cm.threshold {
+		return append([]byte{frameRaw}, data...), nil
+	}
+	compressed, err := cm.c.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{frameCompressed}, compressed...), nil
+}
+
+func (cm *compressedMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return cm.unmarshalLimit(data, v, 0)
+}
+
+func (cm *compressedMarshaler) unmarshalLimit(data []byte, v interface{}, max int) error {
+	if len(data) == 0 {
+		return errors.New("codec: empty compressed frame")
+	}
+	switch data[0] {
+	case frameRaw:
+		data = data[1:]
+	case frameCompressed:
+		var err error
+		if data, err = cm.c.Decompress(data[1:], max); err != nil {
+			return err
+		}
+	default:
+		return errors.New("codec: invalid compression flag")
+	}
+	return cm.m.Unmarshal(data, v)
+}
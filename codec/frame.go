@@ -0,0 +1,166 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+)
+
+// Marshaler 把单个值编码为独立的字节序列，帧编解码器用它编解码每一帧的内容
+type Marshaler interface {
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal 将 data 解码到 v 中，v 为 nil 时丢弃数据
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// DecodeError 表示一帧已经完整读出但内容无法解码，连接上的后续消息不受影响
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "codec: decode frame: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// frameHeaderLen 是帧长度前缀的字节数
+const frameHeaderLen = 4
+
+// FramedCodec 将消息头和消息体分别编码为带 4 字节大端长度前缀的帧，
+// 单个消息体解码失败时整帧已被读出，流的位置不会错乱
+type FramedCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	buf  *bufio.Writer
+	m    Marshaler
+	max  int // 单帧解码后的最大字节数，0 表示不限制
+	size [frameHeaderLen]byte
+}
+
+var _ Codec = (*FramedCodec)(nil)
+
+// NewFramedCodec 函数创建一个新的 Codec 实例，使用 m 编解码每一帧
+func NewFramedCodec(conn io.ReadWriteCloser, m Marshaler) Codec {
+	return &FramedCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+		m:    m,
+	}
+}
+
+// SetMaxMessageSize 限制单个消息头或消息体的字节数，压缩的帧按解压后的大小计算。
+// 超过限制的消息体会被读出并丢弃，连接上的后续消息不受影响
+func (c *FramedCodec) SetMaxMessageSize(n int) {
+	c.max = n
+}
+
+// readFrame 读取一个完整的帧，长度前缀来自对端，帧的内容按块读取。帧的长度超过限制时，skip 为 true 则丢弃该帧并返回 DecodeError，
+// 否则不读取帧的内容直接返回错误
+func (c *FramedCodec) readFrame(skip bool) ([]byte, error) {
+	if _, err := io.ReadFull(c.r, c.size[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(c.size[:])
+	if c.max > 0 && int64(size) > int64(c.max) {
+		err := fmt.Errorf("%w: frame of %d bytes exceeds limit of %d bytes", ErrMessageTooLarge, size, c.max)
+		if !skip {
+			return nil, err
+		}
+		if _, discardErr := io.CopyN(io.Discard, c.r, int64(size)); discardErr != nil {
+			return nil, discardErr
+		}
+		return nil, &DecodeError{Err: err}
+	}
+	data, err := readChunked(c.r, uint64(size))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeFrame 将一帧写入缓冲区
+func (c *FramedCodec) writeFrame(data []byte) error {
+	var size [frameHeaderLen]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+	if _, err := c.buf.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(data)
+	return err
+}
+
+// ReadHeader(*Header) error：读取一帧并解码为消息头
+func (c *FramedCodec) ReadHeader(h *Header) error {
+	data, err := c.readFrame(false)
+	if err != nil {
+		return err
+	}
+	if err = c.unmarshal(data, h); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
+
+// ReadBody(interface{}) error：读取一帧并解码为消息体，body 为 nil 时丢弃该帧
+func (c *FramedCodec) ReadBody(body interface{}) error {
+	data, err := c.readFrame(true)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	if err = c.unmarshal(data, body); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
+
+// unmarshal 解码一帧的内容，解码时数据可能膨胀的 Marshaler 同样受到大小限制
+func (c *FramedCodec) unmarshal(data []byte, v interface{}) error {
+	if lm, ok := c.m.(limitedMarshaler); ok {
+		return lm.unmarshalLimit(data, v, c.max)
+	}
+	return c.m.Unmarshal(data, v)
+}
+
+// Write(*Header, interface{}) error：先完整编码消息头和消息体，再作为两帧写入，
+// 编码失败时不会向连接写入任何数据
+func (c *FramedCodec) Write(h *Header, body interface{}) (err error) {
+	hdr, err := c.m.Marshal(h)
+	if err != nil {
+		log.Println("rpc: frame error encoding header:", err)
+		return err
+	}
+	data, err := c.m.Marshal(body)
+	if err != nil {
+		log.Println("rpc: frame error encoding body:", err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.writeFrame(hdr); err != nil {
+		return
+	}
+	if err = c.writeFrame(data); err != nil {
+		return
+	}
+	return c.buf.Flush()
+}
+
+// Close() error：关闭底层的网络连接。
+func (c *FramedCodec) Close() error {
+	return c.conn.Close()
+}
@@ -2,6 +2,7 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
 	"log"
@@ -9,6 +10,7 @@ import (
 
 type GobCodec struct {
 	conn io.ReadWriteCloser
+	r    *limitReader
 	buf  *bufio.Writer
 	dec  *gob.Decoder
 	enc  *gob.Encoder
@@ -20,25 +22,34 @@ var _ Codec = (*GobCodec)(nil)
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	// 创建一个 bufio.NewWriter，用于缓冲数据
 	buf := bufio.NewWriter(conn)
-	// 返回一个新的 GobCodec 实例，包含了连接、写入器、解码器和编码器
+	r := newLimitReader(conn)
+	// 返回一个新的 GobCodec 实例，包含了连接、读取器、写入器、解码器和编码器
 	return &GobCodec{
 		conn: conn,
+		r:    r,
 		buf:  buf,
-		dec:  gob.NewDecoder(conn),
+		dec:  gob.NewDecoder(r),
 		enc:  gob.NewEncoder(buf),
 	}
 }
 
 // ReadHeader(*Header) error：读取并解码消息的头部
 func (c *GobCodec) ReadHeader(h *Header) error {
+	c.r.begin(c.r.read)
 	return c.dec.Decode(h)
 }
 
 // ReadBody(interface{}) error：读取并解码消息的主体
 func (c *GobCodec) ReadBody(body interface{}) error {
+	c.r.begin(c.r.read)
 	return c.dec.Decode(body)
 }
 
+// SetMaxMessageSize 限制单个消息头或消息体的字节数，超过时解码失败且连接无法继续使用
+func (c *GobCodec) SetMaxMessageSize(n int) {
+	c.r.max = int64(n)
+}
+
 // Write(*Header, interface{}) error：写入编码的消息
 func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 	defer func() {
@@ -62,3 +73,19 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 func (c *GobCodec) Close() error {
 	return c.conn.Close()
 }
+
+// gobMarshaler 为帧编解码器提供 gob 编码，每一帧使用独立的编码器，因此都携带完整的类型信息
+type gobMarshaler struct{}
+
+func (gobMarshaler) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobMarshaler) Unmarshal(data []byte, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+)
+
+type JsonCodec struct {
+	conn io.ReadWriteCloser
+	r    *limitReader
+	buf  *bufio.Writer
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+var _ Codec = (*JsonCodec)(nil)
+
+// NewJsonCodec 函数创建一个新的 Codec 实例，用于处理 json 编码的数据
+func NewJsonCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	r := newLimitReader(conn)
+	return &JsonCodec{
+		conn: conn,
+		r:    r,
+		buf:  buf,
+		dec:  json.NewDecoder(r),
+		enc:  json.NewEncoder(buf),
+	}
+}
+
+// ReadHeader(*Header) error：读取并解码消息的头部
+func (c *JsonCodec) ReadHeader(h *Header) error {
+	c.begin()
+	return c.dec.Decode(h)
+}
+
+// ReadBody(interface{}) error：读取并解码消息的主体，body 为 nil 时丢弃该消息体
+func (c *JsonCodec) ReadBody(body interface{}) error {
+	c.begin()
+	if body == nil {
+		var discard json.RawMessage
+		return c.dec.Decode(&discard)
+	}
+	return c.dec.Decode(body)
+}
+
+// begin 从解码器已经消耗的位置开始计算下一个值的大小，json.Decoder 预读的数据也计入其中
+func (c *JsonCodec) begin() {
+	c.r.begin(c.dec.InputOffset())
+}
+
+// SetMaxMessageSize 限制单个消息头或消息体的字节数，超过时解码失败且连接无法继续使用
+func (c *JsonCodec) SetMaxMessageSize(n int) {
+	c.r.max = int64(n)
+}
+
+// Write(*Header, interface{}) error：写入编码的消息
+func (c *JsonCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.enc.Encode(h); err != nil {
+		log.Println("rpc: json error encoding header:", err)
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		log.Println("rpc: json error encoding body:", err)
+		return
+	}
+	return
+}
+
+// Close() error：关闭底层的网络连接。
+func (c *JsonCodec) Close() error {
+	return c.conn.Close()
+}
+
+// jsonMarshaler 为帧编解码器提供 json 编码
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonMarshaler) Unmarshal(data []byte, v interface{}) error {
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
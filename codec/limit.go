@@ -0,0 +1,114 @@
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMessageTooLarge 表示读取的消息头或消息体超过了允许的最大字节数
+var ErrMessageTooLarge = errors.New("codec: message too large")
+
+// SizeLimiter 由能够限制读取的消息大小的编解码器实现，内置的编解码器都实现了该接口
+type SizeLimiter interface {
+	// SetMaxMessageSize 限制读取的单个消息头或消息体的字节数，0 表示不限制，需要在开始读取前设置
+	SetMaxMessageSize(n int)
+}
+
+// limitReader 统计从 r 中读出的字节数，单个消息读到上限时返回 ErrMessageTooLarge。
+// 它实现了 io.ByteReader，gob 不会再套一层缓冲，读出的字节数就是解码器消耗的字节数
+type limitReader struct {
+	r     *bufio.Reader
+	max   int64 // 单个消息的最大字节数，0 表示不限制
+	read  int64 // 累计读出的字节数
+	limit int64 // 当前消息允许读到的位置
+}
+
+func newLimitReader(r io.Reader) *limitReader {
+	return &limitReader{r: bufio.NewReader(r)}
+}
+
+// begin 开始读取一个新的消息，start 是该消息在流中的起始位置
+func (l *limitReader) begin(start int64) {
+	l.limit = start + l.max
+}
+
+// remaining 返回当前消息还能读取的字节数，不限制时返回 -1
+func (l *limitReader) remaining() (int64, error) {
+	if l.max <= 0 {
+		return -1, nil
+	}
+	n := l.limit - l.read
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: exceeds limit of %d bytes", ErrMessageTooLarge, l.max)
+	}
+	return n, nil
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	n, err := l.remaining()
+	if err != nil {
+		return 0, err
+	}
+	if n >= 0 && int64(len(p)) > n {
+		p = p[:n]
+	}
+	m, err := l.r.Read(p)
+	l.read += int64(m)
+	return m, err
+}
+
+func (l *limitReader) ReadByte() (byte, error) {
+	if _, err := l.remaining(); err != nil {
+		return 0, err
+	}
+	c, err := l.r.ReadByte()
+	if err == nil {
+		l.read++
+	}
+	return c, err
+}
+
+// limitedMarshaler 由解码时数据可能膨胀的 Marshaler 实现，例如解压缩，max 为 0 表示不限制
+type limitedMarshaler interface {
+	unmarshalLimit(data []byte, v interface{}, max int) error
+}
+
+// readAllLimit 读出 r 中的全部数据，超过 max 字节时返回 ErrMessageTooLarge，max 为 0 表示不限制
+func readAllLimit(r io.Reader, max int) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max {
+		return nil, fmt.Errorf("%w: decoded data exceeds limit of %d bytes", ErrMessageTooLarge, max)
+	}
+	return data, nil
+}
+
+// readChunked 从 r 中读取 n 字节；数据按块读取，避免被伪造的长度一次性分配大量内存
+func readChunked(r io.Reader, n uint64) ([]byte, error) {
+	const chunk = 64 << 10
+	if n <= chunk {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+	data := make([]byte, 0, chunk)
+	for uint64(len(data)) < n {
+		m := n - uint64(len(data))
+		if m > chunk {
+			m = chunk
+		}
+		start := len(data)
+		data = append(data, make([]byte, m)...)
+		if _, err := io.ReadFull(r, data[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
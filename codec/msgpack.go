@@ -0,0 +1,901 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type MsgpackCodec struct {
+	conn io.ReadWriteCloser
+	r    *limitReader
+	buf  *bufio.Writer
+	dec  *msgpackDecoder
+	enc  *msgpackEncoder
+}
+
+var _ Codec = (*MsgpackCodec)(nil)
+
+// NewMsgpackCodec 函数创建一个新的 Codec 实例，用于处理 MessagePack 编码的数据
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	r := newLimitReader(conn)
+	return &MsgpackCodec{
+		conn: conn,
+		r:    r,
+		buf:  buf,
+		dec:  &msgpackDecoder{r: r},
+		enc:  &msgpackEncoder{w: buf},
+	}
+}
+
+// ReadHeader(*Header) error：读取并解码消息的头部
+func (c *MsgpackCodec) ReadHeader(h *Header) error {
+	c.r.begin(c.r.read)
+	return c.dec.Decode(h)
+}
+
+// ReadBody(interface{}) error：读取并解码消息的主体，body 为 nil 时跳过该消息体
+func (c *MsgpackCodec) ReadBody(body interface{}) error {
+	c.r.begin(c.r.read)
+	return c.dec.Decode(body)
+}
+
+// SetMaxMessageSize 限制单个消息头或消息体的字节数，超过时解码失败且连接无法继续使用
+func (c *MsgpackCodec) SetMaxMessageSize(n int) {
+	c.r.max = int64(n)
+}
+
+// Write(*Header, interface{}) error：写入编码的消息
+func (c *MsgpackCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.enc.Encode(h); err != nil {
+		log.Println("rpc: msgpack error encoding header:", err)
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		log.Println("rpc: msgpack error encoding body:", err)
+		return
+	}
+	return
+}
+
+// Close() error：关闭底层的网络连接。
+func (c *MsgpackCodec) Close() error {
+	return c.conn.Close()
+}
+
+// msgpackMarshaler 为帧编解码器提供 MessagePack 编码
+type msgpackMarshaler struct{}
+
+func (msgpackMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return appendMsgpack(nil, reflect.ValueOf(v))
+}
+
+func (msgpackMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return (&msgpackDecoder{r: bytes.NewReader(data)}).Decode(v)
+}
+
+// MessagePack 格式的类型标记，参见 https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	mpPosFixintMax = 0x7f
+	mpFixMap       = 0x80
+	mpFixArray     = 0x90
+	mpFixStr       = 0xa0
+	mpNil          = 0xc0
+	mpFalse        = 0xc2
+	mpTrue         = 0xc3
+	mpBin8         = 0xc4
+	mpBin16        = 0xc5
+	mpBin32        = 0xc6
+	mpExt8         = 0xc7
+	mpExt16        = 0xc8
+	mpExt32        = 0xc9
+	mpFloat32      = 0xca
+	mpFloat64      = 0xcb
+	mpUint8        = 0xcc
+	mpUint16       = 0xcd
+	mpUint32       = 0xce
+	mpUint64       = 0xcf
+	mpInt8         = 0xd0
+	mpInt16        = 0xd1
+	mpInt32        = 0xd2
+	mpInt64        = 0xd3
+	mpFixExt1      = 0xd4
+	mpFixExt16     = 0xd8
+	mpStr8         = 0xd9
+	mpStr16        = 0xda
+	mpStr32        = 0xdb
+	mpArray16      = 0xdc
+	mpArray32      = 0xdd
+	mpMap16        = 0xde
+	mpMap32        = 0xdf
+	mpNegFixintMin = 0xe0
+)
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// msgpackField 描述结构体中一个参与编解码的导出字段
+type msgpackField struct {
+	name  string
+	index int
+}
+
+// msgpackFieldCache 缓存每个结构体类型的字段列表，避免重复反射
+var msgpackFieldCache sync.Map // map[reflect.Type][]msgpackField
+
+// cachedFields 返回结构体类型 t 的导出字段，字段名可以通过 `msgpack:"name"` 标签修改，"-" 表示忽略
+func cachedFields(t reflect.Type) []msgpackField {
+	if f, ok := msgpackFieldCache.Load(t); ok {
+		return f.([]msgpackField)
+	}
+	fields := make([]msgpackField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := sf.Name
+		if tag := sf.Tag.Get("msgpack"); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, msgpackField{name: name, index: i})
+	}
+	f, _ := msgpackFieldCache.LoadOrStore(t, fields)
+	return f.([]msgpackField)
+}
+
+// msgpackEncoder 将 Go 值编码为 MessagePack 并写入 w
+type msgpackEncoder struct {
+	w       io.Writer
+	scratch []byte
+}
+
+func (e *msgpackEncoder) Encode(v interface{}) error {
+	b, err := appendMsgpack(e.scratch[:0], reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	e.scratch = b
+	_, err = e.w.Write(b)
+	return err
+}
+
+// appendMsgpack 将 v 的 MessagePack 编码追加到 b 之后
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, mpNil), nil
+	}
+	if v.Type().Implements(binaryMarshalerType) && v.CanInterface() {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		data, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return b, err
+		}
+		return appendBin(b, data), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, mpTrue), nil
+		}
+		return append(b, mpFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32:
+		b = append(b, mpFloat32)
+		return binary.BigEndian.AppendUint32(b, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		b = append(b, mpFloat64)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBin(b, v.Bytes()), nil
+		}
+		return appendArray(b, v)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			return appendBin(b, data), nil
+		}
+		return appendArray(b, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		b = appendHeader(b, v.Len(), mpFixMap, 16, mpMap16, mpMap32)
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			if b, err = appendMsgpack(b, iter.Key()); err != nil {
+				return b, err
+			}
+			if b, err = appendMsgpack(b, iter.Value()); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+		b = appendHeader(b, len(fields), mpFixMap, 16, mpMap16, mpMap32)
+		var err error
+		for _, f := range fields {
+			b = appendString(b, f.name)
+			if b, err = appendMsgpack(b, v.Field(f.index)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		return appendMsgpack(b, v.Elem())
+	default:
+		return b, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+}
+
+func appendArray(b []byte, v reflect.Value) ([]byte, error) {
+	b = appendHeader(b, v.Len(), mpFixArray, 16, mpArray16, mpArray32)
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		if b, err = appendMsgpack(b, v.Index(i)); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// appendHeader 写入 map/array/str 的长度头，长度小于 fixMax 时使用 fix 格式
+func appendHeader(b []byte, n int, fix byte, fixMax int, c16, c32 byte) []byte {
+	switch {
+	case n < fixMax:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, c16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, c32), uint32(n))
+	}
+}
+
+func appendInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(int8(i)))
+	case i >= math.MinInt8:
+		return append(b, mpInt8, byte(int8(i)))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, mpInt16), uint16(int16(i)))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, mpInt32), uint32(int32(i)))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, mpInt64), uint64(i))
+	}
+}
+
+func appendUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= mpPosFixintMax:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, mpUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, mpUint32), uint32(u))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, mpUint64), u)
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, mpFixStr|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, mpStr8, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, mpStr16), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, mpStr32), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendBin(b []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n <= math.MaxUint8:
+		b = append(b, mpBin8, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, mpBin16), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, mpBin32), uint32(n))
+	}
+	return append(b, data...)
+}
+
+// msgpackReader 是解码器需要的读取接口，bufio.Reader 和 bytes.Reader 都满足
+type msgpackReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// msgpackDecoder 从 r 中读取 MessagePack 编码的值
+type msgpackDecoder struct {
+	r     msgpackReader
+	buf   [8]byte
+	depth int // 当前值的嵌套层数
+}
+
+// maxMsgpackDepth 是值的最大嵌套层数，对端构造的深层嵌套不会耗尽解码 Goroutine 的栈
+const maxMsgpackDepth = 10000
+
+var (
+	errMsgpackTarget = errors.New("msgpack: decode target must be a non-nil pointer")
+	errMsgpackDepth  = fmt.Errorf("msgpack: nesting exceeds %d levels", maxMsgpackDepth)
+)
+
+// enter 进入一层嵌套，超过 maxMsgpackDepth 时返回错误；成功时调用方需要在返回前调用 leave
+func (d *msgpackDecoder) enter() error {
+	if d.depth >= maxMsgpackDepth {
+		return errMsgpackDepth
+	}
+	d.depth++
+	return nil
+}
+
+func (d *msgpackDecoder) leave() {
+	d.depth--
+}
+
+// Decode 将下一个值解码到 v 中，v 为 nil 时跳过该值
+func (d *msgpackDecoder) Decode(v interface{}) error {
+	if v == nil {
+		return d.skip()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errMsgpackTarget
+	}
+	return d.decodeValue(rv.Elem())
+}
+
+func (d *msgpackDecoder) decodeValue(v reflect.Value) error {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.decodeWith(c, v)
+}
+
+// decodeWith 使用已经读出的类型标记 c 将值解码到 v 中
+func (d *msgpackDecoder) decodeWith(c byte, v reflect.Value) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	if c == mpNil {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decodeWith(c, v.Elem())
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(binaryUnmarshalerType) {
+		data, err := d.readBytes(c)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: cannot decode into non-empty interface %s", v.Type())
+		}
+		x, err := d.decodeGeneric(c)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	case reflect.Bool:
+		switch c {
+		case mpTrue:
+			v.SetBool(true)
+		case mpFalse:
+			v.SetBool(false)
+		default:
+			return d.typeError(c, v)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.readNumber(c)
+		if err != nil {
+			return err
+		}
+		if n.kind == numFloat || (n.kind == numUint && n.u > math.MaxInt64) || v.OverflowInt(n.i) {
+			return fmt.Errorf("msgpack: value out of range for %s", v.Type())
+		}
+		v.SetInt(n.i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := d.readNumber(c)
+		if err != nil {
+			return err
+		}
+		if n.kind == numFloat || (n.kind == numInt && n.i < 0) || v.OverflowUint(n.u) {
+			return fmt.Errorf("msgpack: value out of range for %s", v.Type())
+		}
+		v.SetUint(n.u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		n, err := d.readNumber(c)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n.float())
+		return nil
+	case reflect.String:
+		data, err := d.readBytes(c)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(data))
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && isMsgpackBytes(c) {
+			data, err := d.readBytes(c)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(data)
+			return nil
+		}
+		n, err := d.readArrayLen(c)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), 0, capHint(n))
+		elem := reflect.New(v.Type().Elem()).Elem()
+		for i := 0; i < n; i++ {
+			elem.Set(reflect.Zero(elem.Type()))
+			if err = d.decodeValue(elem); err != nil {
+				return err
+			}
+			s = reflect.Append(s, elem)
+		}
+		v.Set(s)
+		return nil
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 && isMsgpackBytes(c) {
+			data, err := d.readBytes(c)
+			if err != nil {
+				return err
+			}
+			reflect.Copy(v, reflect.ValueOf(data))
+			return nil
+		}
+		n, err := d.readArrayLen(c)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.Zero(v.Type()))
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				err = d.skip()
+			} else {
+				err = d.decodeValue(v.Index(i))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		n, err := d.readMapLen(c)
+		if err != nil {
+			return err
+		}
+		t := v.Type()
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, capHint(n)))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(t.Key()).Elem()
+			if err = d.decodeValue(key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err = d.decodeValue(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	case reflect.Struct:
+		n, err := d.readMapLen(c)
+		if err != nil {
+			return err
+		}
+		fields := cachedFields(v.Type())
+		for i := 0; i < n; i++ {
+			var name string
+			if err = d.decodeValue(reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			if f, ok := lookupField(fields, name); ok {
+				err = d.decodeValue(v.Field(f.index))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+}
+
+// lookupField 按名称查找字段，先精确匹配再忽略大小写匹配
+func lookupField(fields []msgpackField, name string) (msgpackField, bool) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+	return msgpackField{}, false
+}
+
+func (d *msgpackDecoder) typeError(c byte, v reflect.Value) error {
+	return fmt.Errorf("msgpack: cannot decode type 0x%02x into %s", c, v.Type())
+}
+
+const (
+	numInt = iota
+	numUint
+	numFloat
+)
+
+// msgpackNumber 保存解码得到的数值，kind 表示原始编码类别
+type msgpackNumber struct {
+	kind int
+	i    int64
+	u    uint64
+	f    float64
+}
+
+func (n msgpackNumber) float() float64 {
+	switch n.kind {
+	case numInt:
+		return float64(n.i)
+	case numUint:
+		return float64(n.u)
+	default:
+		return n.f
+	}
+}
+
+func (n msgpackNumber) value() interface{} {
+	switch n.kind {
+	case numInt:
+		return n.i
+	case numUint:
+		return n.u
+	default:
+		return n.f
+	}
+}
+
+func (d *msgpackDecoder) readNumber(c byte) (msgpackNumber, error) {
+	switch {
+	case c <= mpPosFixintMax:
+		return msgpackNumber{kind: numInt, i: int64(c), u: uint64(c)}, nil
+	case c >= mpNegFixintMin:
+		return msgpackNumber{kind: numInt, i: int64(int8(c))}, nil
+	}
+	switch c {
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		u, err := d.readUint(1 << (c - mpUint8))
+		if err != nil {
+			return msgpackNumber{}, err
+		}
+		if u <= math.MaxInt64 {
+			return msgpackNumber{kind: numInt, i: int64(u), u: u}, nil
+		}
+		return msgpackNumber{kind: numUint, u: u}, nil
+	case mpInt8, mpInt16, mpInt32, mpInt64:
+		size := 1 << (c - mpInt8)
+		u, err := d.readUint(size)
+		if err != nil {
+			return msgpackNumber{}, err
+		}
+		// 按位宽做符号扩展
+		shift := 64 - 8*size
+		i := int64(u<<shift) >> shift
+		return msgpackNumber{kind: numInt, i: i, u: uint64(i)}, nil
+	case mpFloat32:
+		u, err := d.readUint(4)
+		if err != nil {
+			return msgpackNumber{}, err
+		}
+		return msgpackNumber{kind: numFloat, f: float64(math.Float32frombits(uint32(u)))}, nil
+	case mpFloat64:
+		u, err := d.readUint(8)
+		if err != nil {
+			return msgpackNumber{}, err
+		}
+		return msgpackNumber{kind: numFloat, f: math.Float64frombits(u)}, nil
+	}
+	return msgpackNumber{}, fmt.Errorf("msgpack: expected number, got type 0x%02x", c)
+}
+
+// readUint 读取 size 字节的大端无符号整数
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	b := d.buf[:size]
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, x := range b {
+		u = u<<8 | uint64(x)
+	}
+	return u, nil
+}
+
+func isMsgpackBytes(c byte) bool {
+	return c&0xe0 == mpFixStr || (c >= mpBin8 && c <= mpBin32) || (c >= mpStr8 && c <= mpStr32)
+}
+
+// readBytes 读取 str 或 bin 类型的数据
+func (d *msgpackDecoder) readBytes(c byte) ([]byte, error) {
+	var n uint64
+	var err error
+	switch {
+	case c&0xe0 == mpFixStr:
+		n = uint64(c & 0x1f)
+	case c >= mpBin8 && c <= mpBin32:
+		n, err = d.readUint(1 << (c - mpBin8))
+	case c >= mpStr8 && c <= mpStr32:
+		n, err = d.readUint(1 << (c - mpStr8))
+	default:
+		return nil, fmt.Errorf("msgpack: expected string or binary, got type 0x%02x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.readN(n)
+}
+
+// readN 读取 n 字节
+func (d *msgpackDecoder) readN(n uint64) ([]byte, error) {
+	return readChunked(d.r, n)
+}
+
+// capHint 限制按长度头预分配的容量，长度头来自对端，不能完全信任
+func capHint(n int) int {
+	const maxHint = 1024
+	if n > maxHint {
+		return maxHint
+	}
+	return n
+}
+
+func (d *msgpackDecoder) readArrayLen(c byte) (int, error) {
+	switch {
+	case c&0xf0 == mpFixArray:
+		return int(c & 0x0f), nil
+	case c == mpArray16:
+		n, err := d.readUint(2)
+		return int(n), err
+	case c == mpArray32:
+		n, err := d.readUint(4)
+		return int(n), err
+	}
+	return 0, fmt.Errorf("msgpack: expected array, got type 0x%02x", c)
+}
+
+func (d *msgpackDecoder) readMapLen(c byte) (int, error) {
+	switch {
+	case c&0xf0 == mpFixMap:
+		return int(c & 0x0f), nil
+	case c == mpMap16:
+		n, err := d.readUint(2)
+		return int(n), err
+	case c == mpMap32:
+		n, err := d.readUint(4)
+		return int(n), err
+	}
+	return 0, fmt.Errorf("msgpack: expected map, got type 0x%02x", c)
+}
+
+// decodeGeneric 在没有目标类型时解码一个值，用于 interface{} 字段
+func (d *msgpackDecoder) decodeGeneric(c byte) (interface{}, error) {
+	if err := d.enter(); err != nil {
+		return nil, err
+	}
+	defer d.leave()
+	switch {
+	case c == mpNil:
+		return nil, nil
+	case c == mpTrue:
+		return true, nil
+	case c == mpFalse:
+		return false, nil
+	case c&0xe0 == mpFixStr || (c >= mpStr8 && c <= mpStr32):
+		data, err := d.readBytes(c)
+		return string(data), err
+	case c >= mpBin8 && c <= mpBin32:
+		return d.readBytes(c)
+	case c&0xf0 == mpFixArray || c == mpArray16 || c == mpArray32:
+		n, err := d.readArrayLen(c)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]interface{}, 0, capHint(n))
+		for i := 0; i < n; i++ {
+			x, err := d.next()
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, x)
+		}
+		return s, nil
+	case c&0xf0 == mpFixMap || c == mpMap16 || c == mpMap32:
+		n, err := d.readMapLen(c)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, capHint(n))
+		allString := true
+		for i := 0; i < n; i++ {
+			k, err := d.next()
+			if err != nil {
+				return nil, err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, errors.New("msgpack: unhashable map key")
+			}
+			if m[k], err = d.next(); err != nil {
+				return nil, err
+			}
+			if _, ok := k.(string); !ok {
+				allString = false
+			}
+		}
+		if !allString {
+			return m, nil
+		}
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[k.(string)] = v
+		}
+		return sm, nil
+	}
+	n, err := d.readNumber(c)
+	if err != nil {
+		return nil, err
+	}
+	return n.value(), nil
+}
+
+func (d *msgpackDecoder) next() (interface{}, error) {
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeGeneric(c)
+}
+
+// skip 跳过下一个完整的值，包括嵌套的 map 和 array
+func (d *msgpackDecoder) skip() error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	var n uint64 // 需要丢弃的字节数
+	switch {
+	case c <= mpPosFixintMax || c >= mpNegFixintMin || c == mpNil || c == mpFalse || c == mpTrue:
+		return nil
+	case c&0xf0 == mpFixMap || c == mpMap16 || c == mpMap32:
+		m, err := d.readMapLen(c)
+		if err != nil {
+			return err
+		}
+		return d.skipN(2 * m)
+	case c&0xf0 == mpFixArray || c == mpArray16 || c == mpArray32:
+		m, err := d.readArrayLen(c)
+		if err != nil {
+			return err
+		}
+		return d.skipN(m)
+	case c&0xe0 == mpFixStr:
+		n = uint64(c & 0x1f)
+	case c >= mpBin8 && c <= mpBin32:
+		n, err = d.readUint(1 << (c - mpBin8))
+	case c >= mpStr8 && c <= mpStr32:
+		n, err = d.readUint(1 << (c - mpStr8))
+	case c >= mpExt8 && c <= mpExt32:
+		n, err = d.readUint(1 << (c - mpExt8))
+		n++ // ext 类型字节
+	case c == mpFloat32:
+		n = 4
+	case c == mpFloat64:
+		n = 8
+	case c >= mpUint8 && c <= mpUint64:
+		n = 1 << (c - mpUint8)
+	case c >= mpInt8 && c <= mpInt64:
+		n = 1 << (c - mpInt8)
+	case c >= mpFixExt1 && c <= mpFixExt16:
+		n = 1 + 1<<(c-mpFixExt1)
+	default:
+		return fmt.Errorf("msgpack: invalid type 0x%02x", c)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(io.Discard, d.r, int64(n))
+	return err
+}
+
+func (d *msgpackDecoder) skipN(n int) error {
+	for i := 0; i < n; i++ {
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -14,14 +14,27 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th><th align=center>One-way errors</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
+			<td align=left font=fixed>{{$name}}({{if $mtype.HasContext}}context.Context, {{end}}{{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumPanics}}</td>
+			<td align=center>{{$mtype.NumOneWayErrors}}</td>
 			</tr>
 		{{end}}
 		</table>
+		{{if .Skipped}}
+		<table>
+		<th align=center>Skipped method</th><th align=center>Reason</th>
+		{{range $name, $reason := .Skipped}}
+			<tr>
+			<td align=left font=fixed>{{$name}}</td>
+			<td align=left>{{$reason}}</td>
+			</tr>
+		{{end}}
+		</table>
+		{{end}}
 	{{end}}
 	</body>
 	</html>`
@@ -33,8 +46,9 @@ type debugHTTP struct {
 }
 
 type debugService struct {
-	Name   string
-	Method map[string]*methodType
+	Name    string
+	Method  map[string]*methodType
+	Skipped map[string]string
 }
 
 // Runs at /debug/geerpc
@@ -44,8 +58,9 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	server.serviceMap.Range(func(namei, svci interface{}) bool {
 		svc := svci.(*service)
 		services = append(services, debugService{
-			Name:   namei.(string),
-			Method: svc.method,
+			Name:    namei.(string),
+			Method:  svc.method,
+			Skipped: svc.skipped,
 		})
 		return true
 	})
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"bytes"
+	"distributed/codec"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ProtocolVersion 是当前实现的协议版本，每次修改线上格式时递增。
+// 版本 1 加入了握手应答，版本 2 加入了客户端发送的取消消息，版本 3 加入了 ping/pong 保活消息，
+// 版本 4 加入了服务端流式调用，版本 5 加入了客户端流式和双向流式调用，版本 6 加入了单向调用，
+// 版本 7 加入了批量调用，版本 8 加入了服务器向客户端发起的反向调用
+const ProtocolVersion = 8
+
+// LegacyVersion 作为 Option.Version 时，客户端使用没有握手应答的旧协议，用于连接只支持旧协议的服务器。
+// 服务器把小于 1 的版本都按旧协议处理，包括旧客户端发送的 0
+const LegacyVersion = -1
+
+// Handshake 是服务器对客户端 Option 的应答，协议版本不低于 1 的客户端在发送 Option 后会收到它
+type Handshake struct {
+	Version      int                 // 双方协商后使用的协议版本
+	CodecType    codec.Type          // 服务器实际选用的编解码器
+	Framed       bool                // 是否使用帧格式
+	Compression  codec.Compression   // 实际使用的压缩算法
+	Codecs       []codec.Type        // 服务器支持的编解码器
+	Compressions []codec.Compression // 服务器支持的压缩算法
+	Error        string              // 非空表示服务器拒绝了连接
+}
+
+// ErrRejected 表示服务器在握手阶段拒绝了连接
+var ErrRejected = errors.New("rpc client: handshake rejected")
+
+// handshake 校验客户端的选项并返回对应的 NewCodecFunc，对旧版本的客户端不发送应答
+func (server *Server) handshake(conn io.Writer, opt *Option) (codec.NewCodecFunc, error) {
+	var f codec.NewCodecFunc
+	var err error
+	if opt.MagicNumber != MagicNumber {
+		err = fmt.Errorf("invalid magic number %x", opt.MagicNumber)
+	} else {
+		f, err = newCodecFunc(opt)
+	}
+	if opt.Version < 1 {
+		return f, err
+	}
+	// 客户端的版本更高时，按服务器支持的版本通信
+	if opt.Version > ProtocolVersion {
+		opt.Version = ProtocolVersion
+	}
+	ack := &Handshake{
+		Version:      opt.Version,
+		CodecType:    opt.CodecType,
+		Framed:       opt.Framed || opt.Compression != codec.CompressNone,
+		Compression:  opt.Compression,
+		Codecs:       supportedCodecs(),
+		Compressions: supportedCompressions(),
+	}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	if encErr := json.NewEncoder(conn).Encode(ack); encErr != nil && err == nil {
+		err = encErr
+	}
+	return f, err
+}
+
+func supportedCodecs() []codec.Type {
+	types := make([]codec.Type, 0, len(codec.NewCodecFuncMap))
+	for t := range codec.NewCodecFuncMap {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func supportedCompressions() []codec.Compression {
+	names := make([]codec.Compression, 0, len(codec.CompressorMap))
+	for name := range codec.CompressorMap {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// readHandshake 读取服务器的握手应答，返回的连接包含 json.Decoder 预读的数据
+func readHandshake(conn io.ReadWriteCloser, opt *Option) (*Handshake, io.ReadWriteCloser, error) {
+	dec := json.NewDecoder(conn)
+	var ack Handshake
+	if err := dec.Decode(&ack); err != nil {
+		return nil, nil, fmt.Errorf("rpc client: read handshake: %w", err)
+	}
+	if ack.Error != "" {
+		return nil, nil, fmt.Errorf("%w: %s", ErrRejected, ack.Error)
+	}
+	if ack.Version < 1 || ack.Version > opt.Version {
+		return nil, nil, fmt.Errorf("rpc client: unsupported protocol version %d", ack.Version)
+	}
+	return &ack, newHandshakeConn(conn, dec.Buffered()), nil
+}
+
+// handshakeConn 把 json.Decoder 读取握手数据时预读的内容交还给编解码器，避免丢失紧随其后的消息
+type handshakeConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func newHandshakeConn(conn io.ReadWriteCloser, buffered io.Reader) io.ReadWriteCloser {
+	// 跳过 json.Encoder 在末尾写入的换行符
+	if b, ok := buffered.(*bytes.Reader); ok {
+		if c, err := b.ReadByte(); err == nil && c != '\n' {
+			_ = b.UnreadByte()
+		}
+	}
+	return &handshakeConn{ReadWriteCloser: conn, r: io.MultiReader(buffered, conn)}
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"fmt"
+	"sync"
+)
+
+// HealthService 是内置健康检查服务的名称。_Health.Check 返回服务的 ServingStatus，
+// 参数为空字符串时返回整个服务器的状态；_Health.Watch 是流式方法，先发送当前状态，之后每次状态改变时发送新的状态
+const HealthService = "_Health"
+
+// ServingStatus 是服务器或服务的服务状态
+type ServingStatus int32
+
+const (
+	StatusUnknown    ServingStatus = iota // 服务不存在
+	StatusServing                         // 正常提供服务
+	StatusNotServing                      // 暂时不提供服务，例如依赖的资源不可用
+	StatusDraining                        // 服务器正在关闭，不再接受新的请求
+)
+
+var statusNames = map[ServingStatus]string{
+	StatusUnknown:    "UNKNOWN",
+	StatusServing:    "SERVING",
+	StatusNotServing: "NOT_SERVING",
+	StatusDraining:   "DRAINING",
+}
+
+func (s ServingStatus) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("ServingStatus(%d)", int32(s))
+}
+
+// healthState 保存服务器和各个服务的服务状态，没有设置过的服务为 StatusServing
+type healthState struct {
+	mu      sync.Mutex
+	status  map[string]ServingStatus // 键为服务名，空字符串表示整个服务器
+	changed chan struct{}            // 状态改变时关闭并替换，通知 Watch
+}
+
+// notify 通知所有 Watch 重新检查状态，调用时需要持有 mu
+func (h *healthState) notify() {
+	if h.changed != nil {
+		close(h.changed)
+	}
+	h.changed = make(chan struct{})
+}
+
+// serviceChanged 在注册、替换或注销服务 name 后通知正在等待的 _Health.Watch，注销时清除该服务的状态
+func (server *Server) serviceChanged(name string, removed bool) {
+	h := &server.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if removed {
+		delete(h.status, name)
+	}
+	h.notify()
+}
+
+// SetServingStatus 设置服务 name 的服务状态，name 为空时设置整个服务器的状态。
+// 整个服务器的状态不是 StatusServing 时，所有服务都报告服务器的状态。Shutdown 会把服务器的状态设为 StatusDraining
+func (server *Server) SetServingStatus(name string, status ServingStatus) {
+	h := &server.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.status == nil {
+		h.status = make(map[string]ServingStatus)
+	}
+	h.status[name] = status
+	h.notify()
+}
+
+// ServingStatus 返回服务 name 的服务状态，name 为空时返回整个服务器的状态，服务不存在时返回 StatusUnknown
+func (server *Server) ServingStatus(name string) ServingStatus {
+	status, _ := server.servingStatus(name)
+	return status
+}
+
+// servingStatus 返回服务 name 的服务状态，以及状态改变时会被关闭的通道
+func (server *Server) servingStatus(name string) (ServingStatus, <-chan struct{}) {
+	known := name == ""
+	if !known {
+		_, known = server.serviceMap.Load(name)
+	}
+	if !known {
+		_, known = server.builtinServices()[name]
+	}
+	h := &server.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.changed == nil {
+		h.changed = make(chan struct{})
+	}
+	if !known {
+		return StatusUnknown, h.changed
+	}
+	overall, ok := h.status[""]
+	if ok && overall != StatusServing {
+		return overall, h.changed
+	}
+	if status, ok := h.status[name]; ok {
+		return status, h.changed
+	}
+	return StatusServing, h.changed
+}
+
+// healthServer 是内置的健康检查服务
+type healthServer struct {
+	server *Server
+}
+
+// Check 返回服务 name 的服务状态，服务不存在时返回 CodeNotFound
+func (h *healthServer) Check(name string, reply *ServingStatus) error {
+	*reply = h.server.ServingStatus(name)
+	if *reply == StatusUnknown {
+		return Errorf(CodeNotFound, "rpc server: can't find service %s", name)
+	}
+	return nil
+}
+
+// Watch 发送服务 name 的当前状态，之后每次状态改变时发送新的状态，直到调用被取消。
+// 服务不存在时发送 StatusUnknown 并继续等待。状态变为 StatusDraining 后流结束，不会阻止服务器关闭
+func (h *healthServer) Watch(name string, stream *ServerStream) error {
+	last := ServingStatus(-1)
+	for {
+		status, changed := h.server.servingStatus(name)
+		if status != last {
+			if err := stream.Send(status); err != nil {
+				return err
+			}
+			last = status
+		}
+		if status == StatusDraining {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"context"
+	"strings"
+)
+
+// CallInfo 描述一次服务端调用，供拦截器使用
+type CallInfo struct {
+	ServiceMethod string   // 格式为 "Service.Method"
+	Service       string   // 服务名
+	Method        string   // 方法名
+	Metadata      Metadata // 请求元数据，与 FromIncomingContext 返回的相同
+}
+
+// Handler 执行一次服务端调用，args 和 reply 分别是请求参数和响应的值
+type Handler func(ctx context.Context, args, reply interface{}) error
+
+// Interceptor 是服务端一元调用的拦截器。拦截器调用 handler 继续执行调用链，
+// 不调用 handler 直接返回错误即可短路本次调用，handler 返回后可以检查或替换错误
+type Interceptor func(ctx context.Context, info *CallInfo, args, reply interface{}, handler Handler) error
+
+// Use 向服务器注册拦截器，拦截器按注册顺序由外向内执行
+func (server *Server) Use(interceptors ...Interceptor) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
+// invoke 经过拦截器链调用请求对应的服务方法
+func (server *Server) invoke(ctx context.Context, req *request) error {
+	handler := func(ctx context.Context, _, _ interface{}) error {
+		return req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+	}
+	server.mu.RLock()
+	interceptors := server.interceptors
+	server.mu.RUnlock()
+	if len(interceptors) == 0 {
+		return handler(ctx, nil, nil)
+	}
+	info := newCallInfo(req)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, args, reply interface{}) error {
+			return interceptor(ctx, info, args, reply, next)
+		}
+	}
+	return handler(ctx, req.argv.Interface(), req.replyv.Interface())
+}
+
+func newCallInfo(req *request) *CallInfo {
+	dot := strings.LastIndex(req.h.ServiceMethod, ".")
+	return &CallInfo{
+		ServiceMethod: req.h.ServiceMethod,
+		Service:       req.h.ServiceMethod[:dot],
+		Method:        req.h.ServiceMethod[dot+1:],
+		Metadata:      req.h.Metadata,
+	}
+}
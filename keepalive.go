@@ -0,0 +1,127 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"log"
+	"time"
+)
+
+// defaultKeepaliveTimeout 是未设置 KeepaliveTimeout 时等待 pong 的时间
+const defaultKeepaliveTimeout = 20 * time.Second
+
+// pingLoop 每隔 interval 调用 ping 发送一次 ping 并等待 pong 的通知，
+// 超过 timeout 没有收到 pong 时返回 false，done 关闭时返回 true
+func pingLoop(done <-chan struct{}, pong <-chan struct{}, ping func(), interval, timeout time.Duration) bool {
+	if timeout <= 0 {
+		timeout = defaultKeepaliveTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return true
+		case <-ticker.C:
+		}
+		// 对端不再读取时发送会被阻塞，放到单独的 Goroutine 中，由超时发现这种情况
+		go ping()
+		timer := time.NewTimer(timeout)
+		select {
+		case <-done:
+			timer.Stop()
+			return true
+		case <-pong:
+			timer.Stop()
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// keepalive 定期向客户端发送 ping，客户端停止回复时关闭连接
+func (server *Server) keepalive(ctx context.Context, sc *serverConn) {
+	ping := func() { sc.writeControl(&codec.Header{Kind: codec.KindPing}) }
+	if !pingLoop(ctx.Done(), sc.pong, ping, server.KeepaliveInterval, server.KeepaliveTimeout) {
+		log.Println("rpc server: keepalive timeout, closing connection")
+		_ = sc.cc.Close()
+	}
+}
+
+// reapIdle 在连接上没有请求的时间超过 IdleTimeout 时通知客户端，并在请求处理完后关闭连接
+func (server *Server) reapIdle(ctx context.Context, sc *serverConn) {
+	timer := time.NewTimer(server.IdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		idle := sc.idleFor()
+		if idle < server.IdleTimeout {
+			timer.Reset(server.IdleTimeout - idle)
+			continue
+		}
+		// 检查之后可能又开始了新的请求，drain 之后等待它们完成
+		sc.drain()
+		select {
+		case <-sc.idle:
+		case <-ctx.Done():
+		}
+		_ = sc.cc.Close()
+		return
+	}
+}
+
+// idleFor 返回连接上没有请求的时长，有请求正在处理时返回 0
+func (sc *serverConn) idleFor() time.Duration {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.inflight > 0 {
+		return 0
+	}
+	return time.Since(sc.lastActive)
+}
+
+// control 处理客户端发送的请求以外的消息，流中的消息和反向调用的响应在这里读入，其他消息的消息体被丢弃。
+// 返回的错误表示无法继续读取连接
+func (sc *serverConn) control(h *codec.Header) error {
+	switch h.Kind {
+	case codec.KindStreamMsg:
+		return sc.receiveStream(h)
+	case codec.KindReverseReply:
+		return sc.receiveReply(h)
+	}
+	if err := sc.cc.ReadBody(nil); err != nil && !isDecodeError(err) {
+		return err
+	}
+	switch h.Kind {
+	case codec.KindCancel:
+		sc.cancel(h.Seq)
+	case codec.KindWindowUpdate:
+		if call := sc.lookup(h.Seq); call != nil && call.stream != nil {
+			call.stream.send.add(h.Window)
+		}
+	case codec.KindCloseSend:
+		if call := sc.lookup(h.Seq); call != nil && call.stream != nil {
+			call.stream.recv.close()
+		}
+	case codec.KindPing:
+		// 在单独的 Goroutine 中回复，读取循环不能被发送阻塞
+		go sc.writeControl(&codec.Header{Kind: codec.KindPong})
+	case codec.KindPong:
+		select {
+		case sc.pong <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// writeControl 向客户端发送一个没有内容的控制消息
+func (sc *serverConn) writeControl(h *codec.Header) {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	_ = sc.cc.Write(h, invalidRequest)
+}
@@ -0,0 +1,139 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"time"
+)
+
+// limiter 限制同时处理的请求数，为 nil 时不限制
+type limiter chan struct{}
+
+func newLimiter(n int) limiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(limiter, n)
+}
+
+// acquireUntil 阻塞直到取得名额或 stop 收到通知，取得名额时返回 true
+func (l limiter) acquireUntil(stop <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (l limiter) tryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// errResourceExhausted 是请求数超过限制时返回给客户端的错误
+var errResourceExhausted = Errorf(CodeResourceExhausted, "rpc server: too many in-flight requests")
+
+// serverLimiter 返回服务器范围的限制器，在第一次使用时按 MaxInflight 创建
+func (server *Server) serverLimiter() limiter {
+	server.limiterOnce.Do(func() {
+		server.limiter = newLimiter(server.MaxInflight)
+	})
+	return server.limiter
+}
+
+// acquire 阻塞直到连接和服务器都有空闲的名额或 stop 收到通知，取得名额时返回 true
+func (server *Server) acquire(sc *serverConn, stop <-chan struct{}) bool {
+	if !sc.limiter.acquireUntil(stop) {
+		return false
+	}
+	if !server.serverLimiter().acquireUntil(stop) {
+		sc.limiter.release()
+		return false
+	}
+	return true
+}
+
+// tryAcquire 尝试获取名额，没有空闲名额时立即返回 false
+func (server *Server) tryAcquire(sc *serverConn) bool {
+	if !sc.limiter.tryAcquire() {
+		return false
+	}
+	if !server.serverLimiter().tryAcquire() {
+		sc.limiter.release()
+		return false
+	}
+	return true
+}
+
+func (server *Server) release(sc *serverConn) {
+	server.serverLimiter().release()
+	sc.limiter.release()
+}
+
+// admit 决定是否处理一个已经读取的请求，acquired 表示调用方已经取得了名额。
+// 没有取得名额时尝试获取，没有空闲名额则返回 errResourceExhausted；返回错误时本函数取得的名额已经释放
+func (server *Server) admit(sc *serverConn, acquired bool) error {
+	if !acquired && !server.tryAcquire(sc) {
+		return errResourceExhausted
+	}
+	if !sc.begin() {
+		if !acquired {
+			server.release(sc)
+		}
+		return errShutdownRefused
+	}
+	return nil
+}
+
+// maxQueuedRequests 是暂停模式下每个连接上等待名额的最大请求数
+const maxQueuedRequests = 64
+
+// queuedRequest 是已经读取、正在等待名额的请求
+type queuedRequest struct {
+	ctx context.Context
+	req *request
+}
+
+// dispatch 按读取顺序处理暂停模式下排队的请求，取得名额后开始处理。
+// 排队期间被客户端取消或所在连接已经关闭的请求直接丢弃，不发送响应；队列关闭并清空后关闭 done
+func (server *Server) dispatch(sc *serverConn, timeout time.Duration, done chan<- struct{}) {
+	defer close(done)
+	for q := range sc.queue {
+		if q.ctx.Err() != nil || !server.acquire(sc, q.ctx.Done()) {
+			sc.untrack(q.req.h.Seq)
+			continue
+		}
+		if err := server.admit(sc, true); err != nil {
+			server.release(sc)
+			sc.untrack(q.req.h.Seq)
+			server.refuse(sc, q.req, err)
+			continue
+		}
+		server.start(q.ctx, sc, q.req, timeout)
+	}
+}
+
+// limitCodec 限制 cc 读取的单个消息的字节数，n 为 0 或编解码器不支持时不做限制
+func limitCodec(cc codec.Codec, n int) codec.Codec {
+	if l, ok := cc.(codec.SizeLimiter); ok && n > 0 {
+		l.SetMaxMessageSize(n)
+	}
+	return cc
+}
@@ -57,8 +57,10 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 	server := NewServer()
 	// 将 foo 变量注册到服务器上
 	_ = server.Register(&foo)
-	// 定时向注册中心发送心跳，维持注册信息
-	registry.Heartbeat(registryAddr, "tcp@"+l.Addr().String(), 0)
+	// 定时向注册中心发送心跳，维持注册信息，并报告服务器的服务状态
+	registry.HeartbeatStatus(registryAddr, "tcp@"+l.Addr().String(), 0, func() string {
+		return server.ServingStatus("").String()
+	})
 	// 标记 WaitGroup 任务完成
 	wg.Done()
 	// 服务器开始接收请求
@@ -66,7 +68,7 @@ func startServer(registryAddr string, wg *sync.WaitGroup) {
 } ////////////
 
 // 处理 RPC 请求的函数。接收者是 xclient 结构体类型的指针，上下文信息，RPC 调用的类型、服务方法名和入参信息
-func foo(xc *xclient.XClient, ctx context.Context, typ, serviceMethod string, args *Args) {
+func foo(xc *XClient, ctx context.Context, typ, serviceMethod string, args *Args) {
 	// 声明一个用于接收返回结果的变量
 	var reply int
 	// 声明一个用于接收错误信息的变量
@@ -93,7 +95,7 @@ func call(registry string) {
 	// 创建一个新的发现实例，用于在注册中心发现服务
 	d := xclient.NewGeeRegistryDiscovery(registry, 0)
 	// 创建一个新的 XClient 实例，用于进行 RPC 调用
-	xc := xclient.NewXClient(d, xclient.RandomSelect, nil)
+	xc := NewXClient(d, xclient.RandomSelect, nil)
 	// 使用 defer 语句确保 xc 资源在函数结束时被正确释放
 	defer func() { _ = xc.Close() }()
 	// 设置一个等待组，用于等待所有的 RPC 调用结束
@@ -117,7 +119,7 @@ func broadcast(registry string) {
 	// 创建一个新的发现实例，用于在注册中心发现服务
 	d := xclient.NewGeeRegistryDiscovery(registry, 0)
 	// 创建一个新的 XClient 实例，用于进行 RPC 调用
-	xc := xclient.NewXClient(d, xclient.RandomSelect, nil)
+	xc := NewXClient(d, xclient.RandomSelect, nil)
 	// 使用 defer 语句确保 xc 资源在函数结束时被正确释放
 	defer func() { _ = xc.Close() }()
 	// 设置一个等待组，用于等待所有的 RPC 调用结束
@@ -131,9 +133,10 @@ func broadcast(registry string) {
 			// 调用服务端的 Foo.Sum 方法
 			foo(xc, context.Background(), "broadcast", "Foo.Sum", &Args{Num1: i, Num2: i * i})
 			// 创建一个上下文，设置了超时时间为 2 秒
-			ctx, _ := context.WithTimeout(context.Background(), time.Second*2)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 			// 在上下文的超时时间内，尝试调用服务端的 Foo.Sleep 方法
 			foo(xc, ctx, "broadcast", "Foo.Sleep", &Args{Num1: i, Num2: i * i})
+			cancel()
 		}(i)
 	}
 	// 等待所有的 RPC 调用结束
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"context"
+	"sync"
+)
+
+// Metadata 是随请求和响应一起传输的键值对，例如 trace ID、认证令牌、租户 ID 和调用方名称
+type Metadata map[string]string
+
+// Pairs 由 k1, v1, k2, v2... 形式的参数构造 Metadata，参数个数为奇数时忽略最后一个
+func Pairs(kv ...string) Metadata {
+	md := make(Metadata, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+// Copy 返回 md 的副本
+func (md Metadata) Copy() Metadata {
+	if md == nil {
+		return nil
+	}
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+type (
+	outgoingMetadataKey struct{}
+	incomingMetadataKey struct{}
+	replyMetadataKey    struct{}
+	replyCaptureKey     struct{}
+)
+
+// NewOutgoingContext 返回携带请求元数据的 context，客户端发起调用时会把它写入请求头
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+// FromOutgoingContext 返回客户端将要发送的请求元数据
+func FromOutgoingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+// FromIncomingContext 在服务方法中返回客户端发送的请求元数据
+func FromIncomingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+// WithReplyMetadata 返回一个 context，调用结束后服务器返回的响应元数据会写入 md
+func WithReplyMetadata(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, replyCaptureKey{}, md)
+}
+
+// replyMetadata 收集服务方法设置的响应元数据
+type replyMetadata struct {
+	mu sync.Mutex
+	md Metadata
+}
+
+// SetReplyMetadata 在服务方法中设置随响应返回的元数据，多次调用会合并
+func SetReplyMetadata(ctx context.Context, md Metadata) bool {
+	rmd, ok := ctx.Value(replyMetadataKey{}).(*replyMetadata)
+	if !ok {
+		return false
+	}
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+	if rmd.md == nil {
+		rmd.md = make(Metadata, len(md))
+	}
+	for k, v := range md {
+		rmd.md[k] = v
+	}
+	return true
+}
+
+func (rmd *replyMetadata) get() Metadata {
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+	return rmd.md
+}
+
+// newIncomingContext 为一次服务端调用构造 context，携带请求元数据和收集响应元数据的容器
+func newIncomingContext(ctx context.Context, md Metadata) (context.Context, *replyMetadata) {
+	rmd := new(replyMetadata)
+	ctx = context.WithValue(ctx, incomingMetadataKey{}, md)
+	return context.WithValue(ctx, replyMetadataKey{}, rmd), rmd
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+)
+
+// PanicHandler 在服务方法发生 panic 并被恢复后调用，可用于上报监控或告警
+type PanicHandler func(info *CallInfo, p interface{}, stack []byte)
+
+// SetPanicHandler 设置服务器的 panic 上报钩子，传入 nil 表示只记录日志
+func (server *Server) SetPanicHandler(h PanicHandler) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.panicHandler = h
+}
+
+// recoverPanic 记录一次被恢复的 panic，返回发送给客户端的错误，堆栈只写入日志不发送给客户端
+func (server *Server) recoverPanic(req *request, p interface{}) error {
+	stack := make([]byte, 64<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+	atomic.AddUint64(&req.mtype.numPanics, 1)
+	log.Printf("rpc server: panic in %s: %v\nTraceback:\n%s\n", req.h.ServiceMethod, p, stack)
+	server.mu.RLock()
+	h := server.panicHandler
+	server.mu.RUnlock()
+	if h != nil {
+		runPanicHandler(h, newCallInfo(req), p, stack)
+	}
+	return Errorf(CodeInternal, "rpc server: panic in %s: %v", req.h.ServiceMethod, p)
+}
+
+// runPanicHandler 调用 panic 上报钩子，钩子自身的 panic 只记录日志，不会让服务器进程崩溃
+func runPanicHandler(h PanicHandler, info *CallInfo, p interface{}, stack []byte) {
+	defer func() {
+		if hp := recover(); hp != nil {
+			log.Printf("rpc server: panic in panic handler for %s: %v\n", info.ServiceMethod, hp)
+		}
+	}()
+	h(info, p, stack)
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ReflectionService 是内置反射服务的名称，以下划线开头，不会与注册的服务冲突。
+// 调用 _Reflection.List 可以列出服务器提供的服务、方法以及参数和返回值的类型
+const ReflectionService = "_Reflection"
+
+// ServiceDesc 描述一个服务
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc      // 按名称排序
+	Skipped map[string]string // 签名不符合要求而没有注册的导出方法，值为原因
+}
+
+// MethodDesc 描述一个服务方法
+type MethodDesc struct {
+	Name   string
+	Stream bool      // 是否为流式方法，流式方法的 Arg 是客户端发送的消息类型，Reply 为空
+	Arg    *TypeDesc // 参数类型
+	Reply  *TypeDesc // 返回值类型
+}
+
+// TypeDesc 描述参数或返回值的类型。指针被忽略，只描述它指向的类型
+type TypeDesc struct {
+	Kind   string      // reflect.Kind 的名称，例如 int、string、struct、slice、map
+	Name   string      // Go 中的类型名，例如 test.ArithArgs、[]int
+	Elem   *TypeDesc   // 数组、切片和 map 的元素类型
+	Key    *TypeDesc   // map 的键类型
+	Fields []FieldDesc // 结构体的导出字段，按声明顺序排列
+}
+
+// FieldDesc 描述结构体的一个字段
+type FieldDesc struct {
+	Name string
+	Tag  string // 字段的结构体标签，JSON 等编解码器根据它决定字段在消息中的名称
+	Type *TypeDesc
+}
+
+// reflection 是内置的反射服务
+type reflection struct {
+	server *Server
+}
+
+// List 返回服务器上的所有服务，name 不为空时只返回该服务
+func (r *reflection) List(name string, reply *[]ServiceDesc) error {
+	var services []*service
+	r.server.serviceMap.Range(func(_, svci interface{}) bool {
+		services = append(services, svci.(*service))
+		return true
+	})
+	for _, svc := range r.server.builtinServices() {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].name < services[j].name })
+	for _, svc := range services {
+		if name == "" || name == svc.name {
+			*reply = append(*reply, describeService(svc))
+		}
+	}
+	if name != "" && len(*reply) == 0 {
+		return Errorf(CodeNotFound, "rpc server: can't find service %s", name)
+	}
+	return nil
+}
+
+// builtinServices 返回服务器内置的服务，它们不存放在 serviceMap 中
+func (server *Server) builtinServices() map[string]*service {
+	server.builtinOnce.Do(func() {
+		server.builtin = map[string]*service{
+			ReflectionService: newBuiltinService(ReflectionService, &reflection{server: server}),
+			HealthService:     newBuiltinService(HealthService, &healthServer{server: server}),
+		}
+	})
+	return server.builtin
+}
+
+// newBuiltinService 以 name 为名称创建内置服务，内置服务的方法总是符合要求的
+func newBuiltinService(name string, rcvr interface{}) *service {
+	s, err := newService(name, rcvr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func describeService(svc *service) ServiceDesc {
+	desc := ServiceDesc{Name: svc.name}
+	if len(svc.skipped) > 0 {
+		desc.Skipped = svc.skipped
+	}
+	for name, mtype := range svc.method {
+		m := MethodDesc{
+			Name:   name,
+			Stream: mtype.Stream,
+			Arg:    describeType(mtype.ArgType, nil),
+		}
+		if !mtype.Stream {
+			m.Reply = describeType(mtype.ReplyType, nil)
+		}
+		desc.Methods = append(desc.Methods, m)
+	}
+	sort.Slice(desc.Methods, func(i, j int) bool { return desc.Methods[i].Name < desc.Methods[j].Name })
+	return desc
+}
+
+// describeType 描述类型 t，visiting 记录正在描述的命名类型，递归引用自身的类型只给出类型名
+func describeType(t reflect.Type, visiting map[reflect.Type]bool) *TypeDesc {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	desc := &TypeDesc{Kind: t.Kind().String(), Name: t.String()}
+	// 只有命名类型能够引用自身，例如 type T []T、type M map[string]M
+	if t.Name() != "" {
+		if visiting[t] {
+			return desc
+		}
+		if visiting == nil {
+			visiting = make(map[reflect.Type]bool)
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+	}
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice:
+		desc.Elem = describeType(t.Elem(), visiting)
+	case reflect.Map:
+		desc.Key = describeType(t.Key(), visiting)
+		desc.Elem = describeType(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			desc.Fields = append(desc.Fields, FieldDesc{Name: f.Name, Tag: string(f.Tag), Type: describeType(f.Type, visiting)})
+		}
+	}
+	return desc
+}
@@ -19,13 +19,18 @@ type GeeRegistry struct {
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Addr   string
+	start  time.Time
+	status string // 心跳中报告的服务状态，空字符串表示服务器没有报告
 }
 
 const (
 	defaultPath    = "/_geerpc_/registry"
 	defaultTimeout = time.Minute * 5
+	// statusServing 是正常提供服务的服务器在心跳中报告的状态，与 ServingStatus 的字符串形式一致
+	statusServing = "SERVING"
+	// statusPollInterval 是 HeartbeatStatus 检查服务状态是否改变的间隔
+	statusPollInterval = time.Second
 )
 
 // New 使用超时设置创建一个注册表实例
@@ -38,28 +43,32 @@ func New(timeout time.Duration) *GeeRegistry {
 
 var DefaultGeeRegister = New(defaultTimeout)
 
-// putServer 将服务器添加到注册表中，或者更新其记录的开始时间
-func (r *GeeRegistry) putServer(addr string) {
+// putServer 将服务器添加到注册表中，或者更新其记录的开始时间和服务状态
+func (r *GeeRegistry) putServer(addr, status string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	s := r.servers[addr]
 	if s == nil {
-		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now()}
+		r.servers[addr] = &ServerItem{Addr: addr, start: time.Now(), status: status}
 	} else {
 		s.start = time.Now() // 如果已经存在，更新开始时间以保持存活
+		s.status = status
 	}
 }
 
-// aliveServers 返回所有存活的服务器地址列表
+// aliveServers 返回所有存活且正常提供服务的服务器地址列表
 func (r *GeeRegistry) aliveServers() []string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	var alive []string
 	for addr, s := range r.servers {
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
-		} else {
+		if r.timeout != 0 && !s.start.Add(r.timeout).After(time.Now()) {
 			delete(r.servers, addr)
+			continue
+		}
+		// 没有报告状态的服务器视为正常
+		if s.status == "" || s.status == statusServing {
+			alive = append(alive, addr)
 		}
 	}
 	sort.Strings(alive)
@@ -78,7 +87,7 @@ func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		r.putServer(addr, req.Header.Get("X-Geerpc-Status"))
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -96,26 +105,56 @@ func HandleHTTP() {
 
 // Heartbeat 是一个辅助函数，用于服务器向注册中心注册或发送心跳
 func Heartbeat(registry, addr string, duration time.Duration) {
+	HeartbeatStatus(registry, addr, duration, nil)
+}
+
+// HeartbeatStatus 与 Heartbeat 相同，并在心跳中报告 status 返回的服务状态，
+// 通常是 func() string { return server.ServingStatus("").String() }。
+// 状态不是 SERVING 的服务器不会被注册中心返回给客户端，状态改变时立即发送心跳，不等待下一个周期
+func HeartbeatStatus(registry, addr string, duration time.Duration, status func() string) {
 	if duration == 0 {
 		// 确保在从注册表中删除之前有足够的时间发送心跳
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
-	var err error
-	err = sendHeartbeat(registry, addr)
+	current := func() string {
+		if status == nil {
+			return ""
+		}
+		return status()
+	}
+	last := current()
+	err := sendHeartbeat(registry, addr, last)
 	go func() {
 		t := time.NewTicker(duration)
+		defer t.Stop()
+		var poll <-chan time.Time
+		if status != nil {
+			p := time.NewTicker(statusPollInterval)
+			defer p.Stop()
+			poll = p.C
+		}
 		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
+			select {
+			case <-t.C:
+			case <-poll:
+				if current() == last {
+					continue
+				}
+			}
+			last = current()
+			err = sendHeartbeat(registry, addr, last)
 		}
 	}()
 }
 
-func sendHeartbeat(registry, addr string) error {
+func sendHeartbeat(registry, addr, status string) error {
 	log.Println(addr, "send heart beat to registry", registry)
 	httpClient := &http.Client{}
 	req, _ := http.NewRequest("POST", registry, nil)
 	req.Header.Set("X-Geerpc-Server", addr)
+	if status != "" {
+		req.Header.Set("X-Geerpc-Status", status)
+	}
 	if _, err := httpClient.Do(req); err != nil {
 		log.Println("rpc server: heart beat err:", err)
 		return err
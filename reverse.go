@@ -0,0 +1,264 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientConn 是服务器到一个客户端连接的句柄，服务方法通过 ClientConnFromContext 取得发起调用的连接，
+// 用它调用客户端通过 Client.Register 注册的服务。反向调用与客户端的请求复用同一个连接，
+// 客户端位于 NAT 之后、服务器无法主动连接它时也可以使用。句柄在服务方法返回后仍然有效，直到连接关闭
+type ClientConn struct {
+	sc      *serverConn
+	version int // 客户端的协议版本
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]*Call
+	closed  bool
+}
+
+type clientConnKey struct{}
+
+// ClientConnFromContext 在服务方法中返回发起调用的连接的句柄
+func ClientConnFromContext(ctx context.Context) (*ClientConn, bool) {
+	c, ok := ctx.Value(clientConnKey{}).(*ClientConn)
+	return c, ok
+}
+
+func newClientConn(sc *serverConn, version int) *ClientConn {
+	return &ClientConn{sc: sc, version: version, pending: make(map[uint64]*Call)}
+}
+
+// Call 调用客户端注册的服务方法并等待它完成。ctx 中的请求元数据和截止时间与 Client.Call 一样发送给客户端
+func (c *ClientConn) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	if c.version < 8 {
+		return errors.New("rpc server: client does not support reverse calls")
+	}
+	md, _ := FromOutgoingContext(ctx)
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Metadata:      md,
+		Done:          make(chan *Call, 1),
+	}
+	if err := c.send(ctx, call); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		c.remove(call.Seq)
+		return &Error{Code: contextCode(ctx.Err()), Message: "rpc server: reverse call failed: " + ctx.Err().Error(), cause: ctx.Err()}
+	case call := <-call.Done:
+		if p, ok := ctx.Value(replyCaptureKey{}).(*Metadata); ok {
+			*p = call.ReplyMetadata
+		}
+		return call.Error
+	}
+}
+
+// send 记录并发送一个反向调用
+func (c *ClientConn) send(ctx context.Context, call *Call) error {
+	c.sc.sending.Lock()
+	defer c.sc.sending.Unlock()
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return &Error{Code: CodeDeadlineExceeded, Message: "rpc server: call deadline exceeded before sending", cause: context.DeadlineExceeded}
+		}
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errReverseConnClosed
+	}
+	call.Seq = c.seq
+	c.seq++
+	c.pending[call.Seq] = call
+	c.mu.Unlock()
+
+	h := &codec.Header{
+		Kind:          codec.KindReverseCall,
+		ServiceMethod: call.ServiceMethod,
+		Seq:           call.Seq,
+		Metadata:      call.Metadata,
+		Timeout:       int64(timeout),
+	}
+	if err := c.sc.cc.Write(h, call.Args); err != nil {
+		c.remove(call.Seq)
+		return &Error{Code: CodeUnavailable, Message: "rpc server: reverse call failed: " + err.Error(), cause: err}
+	}
+	return nil
+}
+
+func (c *ClientConn) remove(seq uint64) *Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call := c.pending[seq]
+	delete(c.pending, seq)
+	return call
+}
+
+// errReverseConnClosed 是连接关闭后反向调用返回的错误
+var errReverseConnClosed = Errorf(CodeUnavailable, "rpc server: client connection closed")
+
+// close 在连接关闭时结束所有等待中的反向调用，之后的调用直接返回错误
+func (c *ClientConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for seq, call := range c.pending {
+		delete(c.pending, seq)
+		call.Error = errReverseConnClosed
+		call.done()
+	}
+}
+
+// receiveReply 在读取循环中读取客户端对反向调用的响应
+func (sc *serverConn) receiveReply(h *codec.Header) error {
+	call := sc.reverse.remove(h.Seq)
+	if call == nil || h.Error != "" {
+		// 调用已经被放弃，或者客户端返回了错误，丢弃消息体
+		if err := sc.cc.ReadBody(nil); err != nil && !isDecodeError(err) {
+			return err
+		}
+		if call != nil {
+			call.ReplyMetadata = h.Metadata
+			call.Error = errorFromHeader(h)
+			call.done()
+		}
+		return nil
+	}
+	call.ReplyMetadata = h.Metadata
+	err := sc.cc.ReadBody(call.Reply)
+	if err != nil {
+		call.Error = &Error{Code: CodeInternal, Message: "reading body " + err.Error(), cause: err}
+	}
+	call.done()
+	if err != nil && !isDecodeError(err) {
+		return err
+	}
+	return nil
+}
+
+// Register 在客户端注册服务，服务器可以通过 ClientConn 调用它。服务名与 Server.Register 一样为 rcvr 的类型名，
+// 需要在服务器发起反向调用之前注册
+func (client *Client) Register(rcvr interface{}) error {
+	s, err := newService("", rcvr)
+	if err != nil {
+		return err
+	}
+	return client.storeService(s)
+}
+
+// RegisterName 与 Register 相同，但以 name 作为服务名
+func (client *Client) RegisterName(name string, rcvr interface{}) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	s, err := newService(name, rcvr)
+	if err != nil {
+		return err
+	}
+	return client.storeService(s)
+}
+
+func (client *Client) storeService(s *service) error {
+	if _, dup := client.services.LoadOrStore(s.name, s); dup {
+		return errors.New("rpc: service already defined: " + s.name)
+	}
+	return nil
+}
+
+// findService 查找客户端注册的服务方法
+func (client *Client) findService(serviceMethod string) (*service, *methodType, error) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, nil, Errorf(CodeInvalidArgument, "rpc client: service/method request ill-formed: %s", serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	svci, ok := client.services.Load(serviceName)
+	if !ok {
+		return nil, nil, Errorf(CodeNotFound, "rpc client: can't find service %s", serviceName)
+	}
+	svc := svci.(*service)
+	mtype := svc.method[methodName]
+	if mtype == nil {
+		return nil, nil, Errorf(CodeNotFound, "rpc client: can't find method %s", methodName)
+	}
+	if mtype.Stream {
+		return nil, nil, Errorf(CodeInvalidArgument, "rpc client: streaming method %s can't be called in reverse", serviceMethod)
+	}
+	return svc, mtype, nil
+}
+
+// receiveCall 在接收循环中读取服务器发起的反向调用，并在单独的 Goroutine 中执行它
+func (client *Client) receiveCall(h *codec.Header) error {
+	req := &request{h: h}
+	var err error
+	req.svc, req.mtype, err = client.findService(h.ServiceMethod)
+	if err == nil {
+		req.argv = req.mtype.newArgv()
+		req.replyv = req.mtype.newReplyv()
+		err = client.cc.ReadBody(req.argvPtr())
+		if err != nil && !isDecodeError(err) {
+			return err
+		}
+		if err != nil {
+			err = Errorf(CodeInvalidArgument, "rpc client: reading body %s", err)
+		}
+	} else if bodyErr := client.cc.ReadBody(nil); bodyErr != nil && !isDecodeError(bodyErr) {
+		return bodyErr
+	}
+	if err != nil {
+		h.Metadata = nil
+		go client.replyCall(h, err, invalidRequest)
+		return nil
+	}
+	go client.serveCall(req)
+	return nil
+}
+
+// serveCall 执行一个反向调用并发送响应
+func (client *Client) serveCall(req *request) {
+	ctx := context.Background()
+	if req.h.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.h.Timeout))
+		defer cancel()
+	}
+	ctx, rmd := newIncomingContext(ctx, req.h.Metadata)
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("rpc client: panic in %s: %v\n", req.h.ServiceMethod, p)
+				err = Errorf(CodeInternal, "rpc client: panic in %s: %v", req.h.ServiceMethod, p)
+			}
+		}()
+		return req.svc.callContext(ctx, req.mtype, req.argv, req.replyv)
+	}()
+	req.h.Metadata = rmd.get()
+	if err != nil {
+		client.replyCall(req.h, err, invalidRequest)
+		return
+	}
+	client.replyCall(req.h, nil, req.replyv.Interface())
+}
+
+// replyCall 向服务器发送反向调用的响应，err 不为 nil 时发送错误
+func (client *Client) replyCall(h *codec.Header, err error, body interface{}) {
+	reply := &codec.Header{Kind: codec.KindReverseReply, ServiceMethod: h.ServiceMethod, Seq: h.Seq, Metadata: h.Metadata}
+	if err != nil {
+		setError(reply, err, CodeApplication)
+	}
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if err := client.cc.Write(reply, body); err != nil {
+		log.Println("rpc client: write reverse reply error:", err)
+	}
+}
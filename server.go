@@ -2,6 +2,8 @@ package test
 
 // 导入 codec 包
 import (
+	"context"
+	"crypto/tls"
 	"distributed/codec"
 	"encoding/json"
 	"errors"
@@ -13,6 +15,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,22 +24,82 @@ const MagicNumber = 0x3bef5c
 
 // Option 结构体，包含了客户端与服务器端通信时所需的所有选项
 type Option struct {
-	MagicNumber    int           // MagicNumber 标记这是一个geerpc请求
-	CodecType      codec.Type    // 客户端可能选择不同的Codec类型来编码body
-	ConnectTimeout time.Duration // 0 代表没有限制
-	HandleTimeout  time.Duration
+	MagicNumber       int               // MagicNumber 标记这是一个geerpc请求
+	Version           int               // 客户端的协议版本，0 表示 ProtocolVersion，LegacyVersion 表示不读取握手应答的旧协议
+	CodecType         codec.Type        // 客户端可能选择不同的Codec类型来编码body
+	Framed            bool              // 为 true 时消息头和消息体以带长度前缀的帧传输
+	Compression       codec.Compression // 消息压缩算法，非空时隐含 Framed，服务器不支持时拒绝连接
+	CompressThreshold int               // 小于该字节数的消息不压缩，0 表示使用默认阈值
+	ConnectTimeout    time.Duration     // 0 代表没有限制
+	HandleTimeout     time.Duration
+	KeepaliveInterval time.Duration `json:"-"` // 客户端发送 ping 的间隔，0 表示不发送
+	KeepaliveTimeout  time.Duration `json:"-"` // 超过该时间没有收到 pong 时关闭连接，0 表示使用默认值
+	MaxResponseSize   int           `json:"-"` // 客户端读取的单个响应头或响应体的最大字节数，0 表示不限制
+	StreamWindow      int           `json:"-"` // 流式调用中客户端为每个流缓冲的消息数，0 表示使用默认值
+	TLSConfig         *tls.Config   `json:"-"` // XDial 使用 tls@addr 时的 TLS 配置，不随选项发送
 }
 
 // 设置默认选项
 var DefaultOption = &Option{
 	MagicNumber:    MagicNumber,
+	Version:        ProtocolVersion,
 	CodecType:      codec.GobType,
 	ConnectTimeout: time.Second * 10,
 }
 
+// newCodecFunc 根据选项选择 NewCodecFunc，Framed 为 true 或开启压缩时用该编解码类型的 Marshaler 构造帧编解码器
+func newCodecFunc(opt *Option) (codec.NewCodecFunc, error) {
+	if !opt.Framed && opt.Compression == codec.CompressNone {
+		f := codec.NewCodecFuncMap[opt.CodecType]
+		if f == nil {
+			return nil, fmt.Errorf("invalid codec type %s", opt.CodecType)
+		}
+		return f, nil
+	}
+	m := codec.MarshalerMap[opt.CodecType]
+	if m == nil {
+		return nil, fmt.Errorf("codec type %s does not support framing", opt.CodecType)
+	}
+	if opt.Compression != codec.CompressNone {
+		c := codec.CompressorMap[opt.Compression]
+		if c == nil {
+			return nil, fmt.Errorf("unsupported compression %s", opt.Compression)
+		}
+		m = codec.NewCompressedMarshaler(m, c, opt.CompressThreshold)
+	}
+	return func(conn io.ReadWriteCloser) codec.Codec {
+		return codec.NewFramedCodec(conn, m)
+	}, nil
+}
+
 // Server 结构代表一个 RPC 服务器，管理服务和处理连接
 type Server struct {
-	serviceMap sync.Map // 存储服务名和服务实例的映射
+	// 以下限制需要在开始接受连接之前设置，0 表示不限制
+	MaxConnInflight int  // 单个连接上同时处理的最大请求数
+	MaxInflight     int  // 整个服务器同时处理的最大请求数
+	RejectOnLimit   bool // 超过限制时立即以 CodeResourceExhausted 拒绝请求，否则请求排队等待空闲名额，每个连接最多排队 maxQueuedRequests 个
+	MaxRequestSize  int  // 单个请求头或请求体的最大字节数，超过时以 CodeResourceExhausted 拒绝请求
+	MaxBatchSize    int  // 单个批量调用的最大调用数，超过时以 CodeResourceExhausted 拒绝，0 表示使用 DefaultMaxBatchSize
+
+	KeepaliveInterval time.Duration // 向客户端发送 ping 的间隔
+	KeepaliveTimeout  time.Duration // 超过该时间没有收到 pong 时关闭连接，0 表示使用默认值
+	IdleTimeout       time.Duration // 连接上没有请求的时间超过该值时关闭连接
+
+	serviceMap   sync.Map     // 存储服务名和服务实例的映射
+	registerMu   sync.Mutex   // 串行化服务的注册、替换和注销
+	mu           sync.RWMutex // 保护以下字段
+	interceptors []Interceptor
+	panicHandler PanicHandler
+	listeners    map[net.Listener]struct{}
+	conns        map[*serverConn]struct{}
+	inShutdown   bool
+
+	limiterOnce sync.Once
+	limiter     limiter // 按 MaxInflight 限制整个服务器的请求数
+
+	builtinOnce sync.Once
+	builtin     map[string]*service // 内置服务，例如 _Reflection
+	health      healthState
 }
 
 // NewServer 返回一个新的 Server 实例
@@ -50,51 +113,139 @@ var DefaultServer = NewServer()
 // 处理单个连接，接收并解析请求，分派到相应的服务
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
-	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error: ", err)
+	// 获取对端信息，TLS 连接在这里完成握手
+	peer, err := newPeer(conn)
+	if err != nil {
+		log.Println("rpc server: tls handshake error:", err)
 		return
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x", opt.MagicNumber)
+	var opt Option
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		log.Println("rpc server: options error: ", err)
 		return
 	}
-	// 根据选项中的 CodecType 字段选择对应的 NewCodecFunc
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodecType)
+	// 校验选项并向客户端发送应答，根据 CodecType、Framed 和 Compression 选择对应的 NewCodecFunc
+	f, err := server.handshake(conn, &opt)
+	if err != nil {
+		log.Println("rpc server:", err)
 		return
 	}
 	// 用选择的编解码器函数处理连接和选项
-	server.serveCodec(f(conn), &opt)
+	ctx := context.WithValue(context.Background(), peerKey{}, peer)
+	cc := limitCodec(f(newHandshakeConn(conn, dec.Buffered())), server.MaxRequestSize)
+	server.serveCodec(ctx, cc, &opt)
 }
 
 // 一个空结构体，作为错误时响应的占位符
 var invalidRequest = struct{}{}
 
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
-	// 初始化一个互斥锁，确保能够发送完整的响应。
-	sending := new(sync.Mutex)
-	// 初始化一个等待组，用于等待所有请求处理完毕。
-	wg := new(sync.WaitGroup)
+// serverConn 保存一个连接上所有请求共享的状态
+type serverConn struct {
+	cc        codec.Codec
+	marshaler codec.Marshaler // 连接的编解码类型对应的 Marshaler，用于编解码批量调用中的参数和返回值
+	limiter   limiter         // 按 MaxConnInflight 限制该连接上的请求数
+	sending   sync.Mutex      // 确保能够发送完整的响应
+	wg        sync.WaitGroup  // 等待所有请求处理完毕
+	mu        sync.Mutex      // 保护以下字段
+	inflight  int
+	draining  bool          // 服务器正在关闭，不再接受新的请求
+	idle      chan struct{} // draining 且没有正在处理的请求时关闭
+
+	calls      map[uint64]*serverCall // 正在处理的请求，同样由 mu 保护
+	lastActive time.Time              // 最近一个请求开始或结束的时间，同样由 mu 保护
+	pong       chan struct{}          // 收到 pong 时通知保活的 Goroutine
+	reverse    *ClientConn            // 服务器向该连接发起反向调用的句柄
+	queue      chan queuedRequest     // 暂停模式下等待名额的请求，拒绝模式下为 nil
+}
+
+// serveCodec 处理一个连接上的所有请求，ctx 携带该连接的对端信息
+func (server *Server) serveCodec(ctx context.Context, cc codec.Codec, opt *Option) {
+	sc := &serverConn{
+		cc:         cc,
+		marshaler:  codec.MarshalerMap[opt.CodecType],
+		limiter:    newLimiter(server.MaxConnInflight),
+		pong:       make(chan struct{}, 1),
+		idle:       make(chan struct{}),
+		lastActive: time.Now(),
+	}
+	if !server.trackConn(sc, true) {
+		_ = cc.Close()
+		return
+	}
+	defer server.trackConn(sc, false)
+	sc.reverse = newClientConn(sc, opt.Version)
+	ctx = context.WithValue(ctx, clientConnKey{}, sc.reverse)
+	// 连接上的请求共享该 context，停止读取请求时取消所有仍在执行的服务方法
+	ctx, cancel := context.WithCancelCause(ctx)
+	if server.KeepaliveInterval > 0 && opt.Version >= 3 {
+		go server.keepalive(ctx, sc)
+	}
+	if server.IdleTimeout > 0 {
+		go server.reapIdle(ctx, sc)
+	}
+	dispatched := make(chan struct{})
+	if !server.RejectOnLimit {
+		sc.queue = make(chan queuedRequest, maxQueuedRequests)
+		go server.dispatch(sc, opt.HandleTimeout, dispatched)
+	}
 	for {
-		req, err := server.readRequest(cc)
+		h, err := server.readRequestHeader(cc)
 		if err != nil {
-			if req == nil {
-				break // 如果请求解析失败，且请求为空，表明出错，直接关闭连接，结束循环
+			break // 如果请求头解析失败，表明出错，直接关闭连接，结束循环
+		}
+		if h.Kind != codec.KindCall && h.Kind != codec.KindOneWay && h.Kind != codec.KindBatch {
+			// 控制消息和流中的消息不占用名额
+			if err = sc.control(h); err != nil {
+				break
 			}
-			// 其他错误时，设置相应的错误信息。
-			req.h.Error = err.Error()
-			// 使用互斥锁发送出错时的响应信息。
-			server.sendResponse(cc, req.h, invalidRequest, sending)
 			continue
 		}
-		wg.Add(1)
-		// 处理正常请求，启动一个新的 Goroutine。
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		var req *request
+		if h.Kind == codec.KindBatch {
+			req, err = server.readBatch(sc, h)
+		} else {
+			req, err = server.readRequest(cc, h)
+		}
+		if err == nil && server.RejectOnLimit {
+			err = server.admit(sc, false)
+		}
+		if err != nil {
+			server.refuse(sc, req, err)
+			if _, ok := err.(*streamError); ok {
+				break // 流的位置已无法确定，不能继续读取下一个请求
+			}
+			continue
+		}
+		// 每个请求有独立的 context，客户端可以按 Seq 取消它，排队等待名额的请求同样可以取消
+		reqCtx, cancelReq := context.WithCancelCause(ctx)
+		if req.mtype != nil && req.mtype.Stream {
+			req.stream = newServerStream(sc, req)
+			req.replyv = reflect.ValueOf(req.stream)
+		}
+		sc.track(req.h.Seq, cancelReq, req.stream)
+		if server.RejectOnLimit {
+			server.start(reqCtx, sc, req, opt.HandleTimeout)
+			continue
+		}
+		// 暂停模式下请求进入队列等待名额，读取循环继续处理取消等控制消息，队列已满时拒绝请求
+		select {
+		case sc.queue <- queuedRequest{ctx: reqCtx, req: req}:
+		default:
+			sc.untrack(req.h.Seq)
+			server.refuse(sc, req, errResourceExhausted)
+		}
+	}
+	cancel(errConnClosed)
+	if sc.queue != nil {
+		// 丢弃仍在排队的请求，之后不会再有请求开始处理
+		close(sc.queue)
+		<-dispatched
 	}
+	// 结束等待中的反向调用，正在等待它们的服务方法才能返回
+	sc.reverse.close()
 	// 等待组等待所有请求处理完毕。
-	wg.Wait()
+	sc.wg.Wait()
 	// 关闭编解码器，释放资源。
 	_ = cc.Close()
 }
@@ -105,6 +256,10 @@ type request struct {
 	argv, replyv reflect.Value // 请求和响应的实际参数
 	mtype        *methodType   // 请求相关的方法类型
 	svc          *service      // 请求相关的服务
+	deadline     time.Time     // 调用方的截止时间，由请求头中剩余的超时时间和到达时间计算
+	stream       *ServerStream // 流式调用的服务端，非流式调用时为 nil
+	batch        []*request    // 批量调用中的各个调用，找不到方法或参数无法解码的为 nil
+	results      []batchResult // 批量调用中各个调用的结果
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
@@ -121,49 +276,126 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = Errorf(CodeInvalidArgument, "rpc server: service/method request ill-formed: %s", serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := server.serviceMap.Load(serviceName)
-	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+	if ok {
+		svc = svci.(*service)
+	} else if svc = server.builtinServices()[serviceName]; svc == nil {
+		err = Errorf(CodeNotFound, "rpc server: can't find service %s", serviceName)
 		return
 	}
-	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = Errorf(CodeNotFound, "rpc server: can't find method %s", methodName)
 	}
 	return
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
-	h, err := server.readRequestHeader(cc)
-	if err != nil {
-		return nil, err
-	}
+// readRequest 读取请求头 h 之后的请求体，返回的错误需要回复给客户端
+func (server *Server) readRequest(cc codec.Codec, h *codec.Header) (*request, error) {
+	var err error
 	req := &request{h: h}
+	if h.Timeout != 0 {
+		req.deadline = time.Now().Add(time.Duration(h.Timeout))
+	}
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// 丢弃请求体，保证下一次读取的是新的消息头
+		if bodyErr := cc.ReadBody(nil); bodyErr != nil && !isDecodeError(bodyErr) {
+			return req, &streamError{bodyErr}
+		}
 		return req, err
 	}
 	req.argv = req.mtype.newArgv()
-	req.replyv = req.mtype.newReplyv()
+	if !req.mtype.Stream {
+		req.replyv = req.mtype.newReplyv()
+	}
 
-	// 确保 argvi 是一个指针，因为 ReadBody 需要指针参数
-	argvi := req.argv.Interface()
+	// 读取请求体
+	if err = cc.ReadBody(req.argvPtr()); err != nil {
+		return req, bodyError(err)
+	}
+	// 流式方法只能以流的方式调用，反之亦然
+	if req.mtype.Stream && h.Kind == codec.KindOneWay {
+		return req, Errorf(CodeInvalidArgument, "rpc server: streaming method %s can't be called one-way", h.ServiceMethod)
+	}
+	if req.mtype.Stream && h.Window == 0 {
+		return req, Errorf(CodeInvalidArgument, "rpc server: %s is a streaming method", h.ServiceMethod)
+	}
+	if !req.mtype.Stream && h.Window > 0 {
+		return req, Errorf(CodeInvalidArgument, "rpc server: %s is not a streaming method", h.ServiceMethod)
+	}
+	return req, nil
+}
+
+// argvPtr 返回指向参数的指针，因为 ReadBody 需要指针参数
+func (req *request) argvPtr() interface{} {
 	if req.argv.Type().Kind() != reflect.Ptr {
-		argvi = req.argv.Addr().Interface()
+		return req.argv.Addr().Interface()
 	}
+	return req.argv.Interface()
+}
 
-	// 读取请求体
-	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err:", err)
-		return req, err
+// bodyError 把读取请求体的错误转换为回复给客户端的错误，流的位置无法确定时包装为 *streamError
+func bodyError(err error) error {
+	log.Println("rpc server: read body err:", err)
+	st := &Error{Code: CodeInvalidArgument, Message: err.Error()}
+	if errors.Is(err, codec.ErrMessageTooLarge) {
+		st.Code = CodeResourceExhausted
 	}
+	if !isDecodeError(err) {
+		return &streamError{st}
+	}
+	return st
+}
 
-	return req, nil
+// streamError 表示读取请求体失败且流的位置已无法确定，回复该请求后必须关闭连接
+type streamError struct {
+	error
+}
+
+func (e *streamError) Unwrap() error {
+	return e.error
+}
+
+// isDecodeError 判断 err 是否为帧编解码器的解码错误，此时出错的帧已被完整读出，可以继续读取后续消息
+func isDecodeError(err error) bool {
+	var de *codec.DecodeError
+	return errors.As(err, &de)
+}
+
+// refuse 以 err 回复一个不会被处理的请求，不回传请求元数据
+func (server *Server) refuse(sc *serverConn, req *request, err error) {
+	setError(req.h, err, CodeUnknown)
+	req.h.Metadata = nil
+	server.respond(sc, req, invalidRequest)
+}
+
+// start 在新的 Goroutine 中处理一个已经取得名额的请求
+func (server *Server) start(ctx context.Context, sc *serverConn, req *request, timeout time.Duration) {
+	if req.h.Kind == codec.KindBatch {
+		go server.handleBatch(ctx, sc, req, timeout)
+		return
+	}
+	go server.handleRequest(ctx, sc, req, timeout)
+}
+
+// respond 发送请求的响应。单向调用不发送响应，失败时只记录日志并计入方法的 NumOneWayErrors
+func (server *Server) respond(sc *serverConn, req *request, body interface{}) {
+	if req.h.Kind != codec.KindOneWay {
+		server.sendResponse(sc.cc, req.h, body, &sc.sending)
+		return
+	}
+	if req.h.Error == "" {
+		return
+	}
+	if req.mtype != nil {
+		atomic.AddUint64(&req.mtype.numOneWayErrors, 1)
+	}
+	log.Printf("rpc server: one-way call %s failed: %s\n", req.h.ServiceMethod, req.h.Error)
 }
 
 func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
@@ -174,50 +406,108 @@ func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interfa
 	}
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
-	defer wg.Done()
+// errConnClosed 是连接关闭时取消请求 context 的原因
+var errConnClosed = errors.New("rpc server: connection closed")
+
+// errDeadlineExceeded 是调用方的截止时间到期时取消请求 context 的原因
+var errDeadlineExceeded = Errorf(CodeDeadlineExceeded, "rpc server: call deadline exceeded")
+
+// handleRequest 调用服务方法并发送响应，每个请求都只由这里发送一次响应，单向调用除外。
+// 超时后立即发送错误响应，但名额要等服务方法真正返回后才释放，Shutdown 同样等待它返回
+func (server *Server) handleRequest(ctx context.Context, sc *serverConn, req *request, timeout time.Duration) {
+	defer sc.end()
+	defer server.release(sc)
+	defer sc.untrack(req.h.Seq)
+
+	body, ok, done := server.runRequest(ctx, req, timeout)
+	if ok {
+		server.respond(sc, req, body)
+	}
+	<-done
+}
+
+// runRequest 调用服务方法，把错误和响应元数据写入 req.h 并返回响应体。服务方法在独立的 Goroutine 中执行，
+// 超时或连接关闭时取消它的 ctx；连接已关闭或客户端已经放弃了调用时 ok 为 false，不应再发送响应。
+// 超时时服务方法可能仍在执行，done 在它返回后关闭
+func (server *Server) runRequest(ctx context.Context, req *request, timeout time.Duration) (body interface{}, ok bool, done <-chan struct{}) {
+	finished := make(chan struct{})
+	if !req.deadline.IsZero() && !time.Now().Before(req.deadline) {
+		// 到达时已经过期的请求不再执行
+		close(finished)
+		setError(req.h, errDeadlineExceeded, CodeUnknown)
+		req.h.Metadata = nil
+		return invalidRequest, true, finished
+	}
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout,
+			Errorf(CodeDeadlineExceeded, "rpc server: request handle timeout: expect within %s", timeout))
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	if !req.deadline.IsZero() {
+		// 调用方的截止时间更早时以它为准，服务方法发起的嵌套调用会继承它
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadlineCause(ctx, req.deadline, errDeadlineExceeded)
+		defer cancelDeadline()
+	}
+	// 服务方法通过 ctx 读取请求元数据并设置响应元数据
+	ctx, rmd := newIncomingContext(ctx, req.h.Metadata)
+	if req.stream != nil {
+		req.stream.ctx = ctx
+	}
 
-	// 调用服务方法，记录请求处理完成
-	called := make(chan struct{})
-	sent := make(chan struct{})
+	// called 带缓冲，即使已经超时，服务方法返回后 Goroutine 也能立即退出
+	called := make(chan error, 1)
 	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
-		// 通知处理完成
-		called <- struct{}{}
-		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
-			// 通知响应发送完成
-			sent <- struct{}{}
-			return
-		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		// 通知响应发送完成
-		sent <- struct{}{}
+		defer close(finished)
+		// 服务方法或拦截器中的 panic 只影响本次调用
+		defer func() {
+			if p := recover(); p != nil {
+				called <- server.recoverPanic(req, p)
+			}
+		}()
+		called <- server.invoke(ctx, req)
 	}()
-	if timeout == 0 {
-		// 等待调用完成和发送完成
-		<-called
-		<-sent
-		return
-	}
-	// 使用 select 等待事件发生，或超时
+
 	select {
-	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
-	case <-called:
-		// 调用完成，等待响应发送完成
-		<-sent
+	case <-ctx.Done():
+		cause := context.Cause(ctx)
+		if cause == errConnClosed || cause == errCallCanceled {
+			return nil, false, finished // 连接已关闭或客户端已经放弃了调用，不再发送响应
+		}
+		setError(req.h, cause, CodeUnknown)
+		req.h.Metadata = nil
+		return invalidRequest, true, finished
+	case err := <-called:
+		req.h.Metadata = rmd.get()
+		if err != nil {
+			// 服务方法返回的普通错误视为应用错误
+			setError(req.h, err, CodeApplication)
+			return invalidRequest, true, finished
+		}
+		if req.stream != nil {
+			// 流中的消息已经发送完毕，以一个没有内容的响应结束流
+			return invalidRequest, true, finished
+		}
+		return req.replyv.Interface(), true, finished
 	}
 }
 
 // Accept 函数用于接受网络连接，并为每个连接启动一个 Goroutine 来处理请求
 func (server *Server) Accept(lis net.Listener) {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			if !server.shuttingDown() {
+				log.Println("rpc server: accept error:", err)
+			}
 			return
 		}
 		// 为每个连接创建一个新的 Goroutine 来服务
@@ -229,13 +519,79 @@ func Accept(lis net.Listener) {
 	DefaultServer.Accept(lis)
 }
 
-// Register 函数用于向 RPC 服务器注册服务
+// Register 函数用于向 RPC 服务器注册服务，服务名为 rcvr 的类型名。
+// 签名不符合要求的导出方法会被跳过并记录日志，没有任何方法可以注册时返回错误
 func (server *Server) Register(rcvr interface{}) error {
-	// 创建服务实例
-	s := newService(rcvr)
-	// 尝试加载或存储服务到同步映射
-	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
+	s, err := newService("", rcvr)
+	if err != nil {
+		return err
+	}
+	return server.storeService(s, false)
+}
+
+// RegisterName 与 Register 相同，但以 name 作为服务名，rcvr 的类型不要求是导出的。
+// 以下划线开头的名称留给内置服务
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	s, err := newService(name, rcvr)
+	if err != nil {
+		return err
+	}
+	return server.storeService(s, false)
+}
+
+// Replace 用 rcvr 替换已经注册的服务 name。替换是原子的：正在处理的调用继续使用原来的实现，
+// 之后到达的调用使用 rcvr，不会有调用找不到服务
+func (server *Server) Replace(name string, rcvr interface{}) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	s, err := newService(name, rcvr)
+	if err != nil {
+		return err
+	}
+	return server.storeService(s, true)
+}
+
+// Unregister 注销服务 name，之后的调用返回 CodeNotFound，正在处理的调用不受影响
+func (server *Server) Unregister(name string) error {
+	server.registerMu.Lock()
+	_, ok := server.serviceMap.LoadAndDelete(name)
+	server.registerMu.Unlock()
+	if !ok {
+		return errors.New("rpc: service not registered: " + name)
+	}
+	server.serviceChanged(name, true)
+	return nil
+}
+
+// storeService 保存服务 s，replace 为 true 时要求服务已经存在，否则要求服务不存在
+func (server *Server) storeService(s *service, replace bool) error {
+	server.registerMu.Lock()
+	_, exists := server.serviceMap.Load(s.name)
+	switch {
+	case exists && !replace:
+		server.registerMu.Unlock()
 		return errors.New("rpc: service already defined: " + s.name)
+	case !exists && replace:
+		server.registerMu.Unlock()
+		return errors.New("rpc: service not registered: " + s.name)
+	}
+	server.serviceMap.Store(s.name, s)
+	server.registerMu.Unlock()
+	server.serviceChanged(s.name, false)
+	return nil
+}
+
+// checkServiceName 检查 RegisterName 和 Replace 的服务名
+func checkServiceName(name string) error {
+	if name == "" {
+		return errors.New("rpc: service name is empty")
+	}
+	if strings.HasPrefix(name, "_") {
+		return errors.New("rpc: service names starting with _ are reserved: " + name)
 	}
 	return nil
 }
@@ -244,6 +600,10 @@ func Register(rcvr interface{}) error {
 	return DefaultServer.Register(rcvr)
 }
 
+func RegisterName(name string, rcvr interface{}) error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
+
 const (
 	connected        = "200 Connected to Gee RPC"
 	defaultRPCPath   = "/_geeprc_"
@@ -0,0 +1,1049 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"distributed/xclient"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Arith int
+
+type ArithArgs struct {
+	A, B int
+}
+
+func (a Arith) Add(args ArithArgs, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (a Arith) Sleep(args ArithArgs, reply *int) error {
+	time.Sleep(time.Millisecond * time.Duration(args.A))
+	*reply = args.A + args.B
+	return nil
+}
+
+// Caller 返回请求元数据中的 caller，并在响应元数据中回传 trace-id
+func (a Arith) Caller(ctx context.Context, args ArithArgs, reply *string) error {
+	md, _ := FromIncomingContext(ctx)
+	*reply = md["caller"]
+	SetReplyMetadata(ctx, Pairs("trace-id", md["trace-id"]))
+	return nil
+}
+
+// waitCanceled 接收 Arith.Wait 观察到的 ctx 取消原因
+var waitCanceled = make(chan error, 1)
+
+// Wait 阻塞到 ctx 被取消
+func (a Arith) Wait(ctx context.Context, args ArithArgs, reply *int) error {
+	<-ctx.Done()
+	waitCanceled <- ctx.Err()
+	return ctx.Err()
+}
+
+// Echo 原样返回参数
+func (a Arith) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+// Remaining 返回 ctx 剩余的时间，单位毫秒，没有截止时间时返回 -1
+func (a Arith) Remaining(ctx context.Context, args ArithArgs, reply *int) error {
+	*reply = -1
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = int(time.Until(deadline) / time.Millisecond)
+	}
+	return nil
+}
+
+// rangeSent 记录 Arith.Range 发送的消息数
+var rangeSent int64
+
+// Range 依次发送 [A, B) 中的整数，A 为负数时返回错误
+func (a Arith) Range(args ArithArgs, stream *ServerStream) error {
+	if args.A < 0 {
+		return Errorf(CodeInvalidArgument, "negative start")
+	}
+	for i := args.A; i < args.B; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+		atomic.AddInt64(&rangeSent, 1)
+	}
+	return nil
+}
+
+// Sum 读取客户端发送的所有整数，返回它们的和
+func (a Arith) Sum(first int, stream *ServerStream) error {
+	sum := first
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return stream.Send(sum)
+		}
+		if err != nil {
+			return err
+		}
+		sum += n
+	}
+}
+
+// Double 把客户端发送的每个整数乘以 2 后发回
+func (a Arith) Double(n int, stream *ServerStream) error {
+	for {
+		if err := stream.Send(n * 2); err != nil {
+			return err
+		}
+		if err := stream.Recv(&n); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// notified 接收 Arith.Notify 收到的参数
+var notified = make(chan string, 1)
+
+// Notify 把参数交给 notified，用于测试单向调用
+func (a Arith) Notify(args string, reply *int) error {
+	notified <- args
+	return nil
+}
+
+// callbacks 接收 Arith.Callback 取得的连接句柄
+var callbacks = make(chan *ClientConn, 1)
+
+// Callback 调用客户端注册的方法 args，返回它的结果
+func (a Arith) Callback(ctx context.Context, args string, reply *string) error {
+	conn, ok := ClientConnFromContext(ctx)
+	if !ok {
+		return errors.New("no client connection in context")
+	}
+	select {
+	case callbacks <- conn:
+	default:
+	}
+	return conn.Call(ctx, args, "ping", reply)
+}
+
+// gatewayCalls 记录 Arith.Gateway 被执行的次数
+var gatewayCalls int64
+
+// Gateway 执行后返回 CodeUnavailable，模拟下游服务不可用
+func (a Arith) Gateway(args ArithArgs, reply *int) error {
+	atomic.AddInt64(&gatewayCalls, 1)
+	return Errorf(CodeUnavailable, "payment gateway unavailable")
+}
+
+// Panic 总是 panic，用于测试服务器的恢复
+func (a Arith) Panic(args ArithArgs, reply *int) error {
+	panic("boom")
+}
+
+// Div 在除数为 0 时返回带错误码和详情的错误
+func (a Arith) Div(args ArithArgs, reply *int) error {
+	if args.B == 0 {
+		e := Errorf(CodeInvalidArgument, "divide by zero")
+		e.Details = map[string]string{"field": "B"}
+		return e
+	}
+	if args.A < 0 {
+		return errors.New("negative dividend")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+// newTestServer 启动一个注册了 Arith 的服务器，返回其监听地址
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	server := NewServer()
+	var arith Arith
+	_assert(server.Register(&arith) == nil, "register Arith")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func dialTest(t *testing.T, addr string, opt *Option) *Client {
+	t.Helper()
+	client, err := Dial("tcp", addr, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestFramedCodecSkipsBadBody(t *testing.T) {
+	_, addr := newTestServer(t)
+	for typ := range codec.MarshalerMap {
+		client := dialTest(t, addr, &Option{CodecType: typ, Framed: true})
+		ctx := context.Background()
+		var reply int
+		err := client.Call(ctx, "Arith.Add", "not an ArithArgs", &reply)
+		_assert(err != nil, "%s: expect decode error for bad args", typ)
+		err = client.Call(ctx, "Arith.Missing", &ArithArgs{A: 1, B: 2}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "%s: expect unknown method, got %v", typ, err)
+		err = client.Call(ctx, "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+		_assert(err == nil && reply == 3, "%s: connection should survive a bad body, got %v", typ, err)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	_, addr := newTestServer(t)
+	for name := range codec.CompressorMap {
+		client := dialTest(t, addr, &Option{CodecType: codec.MsgpackType, Compression: name, CompressThreshold: 1})
+		var reply int
+		err := client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+		_assert(err == nil && reply == 3, "%s: call failed: %v", name, err)
+	}
+	_, err := Dial("tcp", addr, &Option{Compression: "lz4"})
+	_assert(err != nil, "expect unsupported compression error")
+}
+
+func TestHandshake(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, &Option{CodecType: codec.JsonType})
+	_assert(client.handshake != nil && client.handshake.Version == ProtocolVersion, "expect handshake acknowledgement")
+	_assert(client.handshake.CodecType == codec.JsonType, "expect chosen codec %s, got %s", codec.JsonType, client.handshake.CodecType)
+
+	// 服务器拒绝时客户端得到描述性的错误
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	_, err = NewClient(conn, &Option{MagicNumber: 0x1, Version: ProtocolVersion, CodecType: codec.GobType})
+	_assert(errors.Is(err, ErrRejected) && strings.Contains(err.Error(), "magic number"), "expect rejection, got %v", err)
+
+	// 旧版本客户端不读取应答，仍然可以正常调用
+	conn, err = net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	legacy, err := NewClient(conn, &Option{MagicNumber: MagicNumber, Version: LegacyVersion, CodecType: codec.GobType})
+	_assert(err == nil && legacy.handshake == nil, "legacy client: %v", err)
+	defer func() { _ = legacy.Close() }()
+	var reply int
+	err = legacy.Call(context.Background(), "Arith.Add", &ArithArgs{A: 2, B: 3}, &reply)
+	_assert(err == nil && reply == 5, "legacy call failed: %v", err)
+
+	// Dial 把 0 替换为当前版本，LegacyVersion 才使用旧协议
+	current := dialTest(t, addr, &Option{Version: 0})
+	_assert(current.handshake != nil && current.handshake.Version == ProtocolVersion, "expect current protocol for version 0")
+	dialed := dialTest(t, addr, &Option{Version: LegacyVersion})
+	_assert(dialed.handshake == nil, "expect legacy protocol for LegacyVersion")
+	err = dialed.Call(context.Background(), "Arith.Add", &ArithArgs{A: 3, B: 4}, &reply)
+	_assert(err == nil && reply == 7, "legacy call through Dial failed: %v", err)
+}
+
+func TestMetadata(t *testing.T) {
+	_, addr := newTestServer(t)
+	for typ := range codec.NewCodecFuncMap {
+		client := dialTest(t, addr, &Option{CodecType: typ})
+		var replyMD Metadata
+		ctx := NewOutgoingContext(context.Background(), Pairs("caller", "tester", "trace-id", "t-1"))
+		ctx = WithReplyMetadata(ctx, &replyMD)
+		var reply string
+		err := client.Call(ctx, "Arith.Caller", &ArithArgs{}, &reply)
+		_assert(err == nil && reply == "tester", "%s: expect caller from metadata, got %q %v", typ, reply, err)
+		_assert(replyMD["trace-id"] == "t-1", "%s: expect reply metadata, got %v", typ, replyMD)
+	}
+}
+
+func TestHandleTimeoutCancelsMethod(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, &Option{HandleTimeout: 50 * time.Millisecond})
+	var reply int
+	err := client.Call(context.Background(), "Arith.Wait", &ArithArgs{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect handle timeout, got %v", err)
+	select {
+	case err = <-waitCanceled:
+		_assert(err == context.DeadlineExceeded, "expect deadline exceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("method context was not cancelled on timeout")
+	}
+	// 连接上不会再出现该请求的第二个响应，后续调用正常
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 1}, &reply)
+	_assert(err == nil && reply == 2, "call after timeout failed: %v", err)
+
+	// 连接关闭时取消仍在执行的服务方法
+	client2 := dialTest(t, addr, nil)
+	go func() { _ = client2.Call(context.Background(), "Arith.Wait", &ArithArgs{}, &reply) }()
+	time.Sleep(50 * time.Millisecond)
+	_ = client2.Close()
+	select {
+	case err = <-waitCanceled:
+		_assert(err == context.Canceled, "expect canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("method context was not cancelled on connection close")
+	}
+}
+
+func TestHandleTimeoutKeepsSlot(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.MaxConnInflight = 1
+	server.RejectOnLimit = true
+	client := dialTest(t, addr, &Option{HandleTimeout: 50 * time.Millisecond})
+
+	// 不接收 ctx 的服务方法超时后仍在执行，它返回之前名额不会被释放
+	start := time.Now()
+	var reply int
+	err := client.Call(context.Background(), "Arith.Sleep", &ArithArgs{A: 200}, &reply)
+	_assert(ErrorCode(err) == CodeDeadlineExceeded && time.Since(start) < 150*time.Millisecond, "expect handle timeout, got %v", err)
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 1}, &reply)
+	_assert(ErrorCode(err) == CodeResourceExhausted, "expect the slot to be held by the running method, got %v", err)
+
+	// Shutdown 等待超时后仍在执行的服务方法返回
+	_ = server.Shutdown(context.Background())
+	_assert(time.Since(start) >= 200*time.Millisecond, "shutdown returned before the method finished")
+}
+
+func TestInterceptors(t *testing.T) {
+	server, addr := newTestServer(t)
+	var order []string
+	server.Use(func(ctx context.Context, info *CallInfo, args, reply interface{}, handler Handler) error {
+		order = append(order, "auth:"+info.Method)
+		if info.Metadata["token"] != "secret" {
+			return errors.New("unauthenticated")
+		}
+		return handler(ctx, args, reply)
+	}, func(ctx context.Context, info *CallInfo, args, reply interface{}, handler Handler) error {
+		err := handler(ctx, args, reply)
+		order = append(order, fmt.Sprintf("log:%v=%d", args, *reply.(*int)))
+		return err
+	})
+	client := dialTest(t, addr, nil)
+	var reply int
+	err := client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "unauthenticated"), "expect short-circuit, got %v", err)
+	ctx := NewOutgoingContext(context.Background(), Pairs("token", "secret"))
+	err = client.Call(ctx, "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(err == nil && reply == 3, "call failed: %v", err)
+	want := []string{"auth:Add", "auth:Add", "log:{1 2}=3"}
+	_assert(fmt.Sprint(order) == fmt.Sprint(want), "unexpected interceptor order %v", order)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	server, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	slow := client.Go("Arith.Sleep", &ArithArgs{A: 200, B: 1}, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	for client.IsAvailable() {
+		time.Sleep(5 * time.Millisecond)
+	}
+	var reply int
+	err := client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(errors.Is(err, ErrServerShutdown) && ErrorCode(err) == CodeUnavailable, "expect ErrServerShutdown, got %v", err)
+
+	call := <-slow.Done
+	_assert(call.Error == nil && *call.Reply.(*int) == 201, "in-flight call should finish, got %v", call.Error)
+	_assert(<-shutdown == nil, "shutdown should wait for in-flight calls")
+	_, err = Dial("tcp", addr, &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "listener should be closed after shutdown")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	server, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	client.Go("Arith.Sleep", &ArithArgs{A: 500}, new(int), nil)
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	_assert(err == context.DeadlineExceeded, "expect deadline exceeded, got %v", err)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	server, addr := newTestServer(t)
+	reported := make(chan string, 1)
+	server.SetPanicHandler(func(info *CallInfo, p interface{}, stack []byte) {
+		reported <- fmt.Sprintf("%s:%v", info.ServiceMethod, p)
+	})
+	client := dialTest(t, addr, nil)
+	var reply int
+	err := client.Call(context.Background(), "Arith.Panic", &ArithArgs{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "panic in Arith.Panic: boom"), "expect panic error, got %v", err)
+	_assert(<-reported == "Arith.Panic:boom", "panic handler not called")
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(err == nil && reply == 3, "server should survive a panic, got %v", err)
+	svci, _ := server.serviceMap.Load("Arith")
+	mtype := svci.(*service).method["Panic"]
+	_assert(mtype.NumPanics() == 1 && mtype.NumCalls() == 1, "expect 1 panic, got %d", mtype.NumPanics())
+
+	// 上报钩子自身 panic 时调用方仍然收到 CodeInternal，服务器继续运行
+	server.SetPanicHandler(func(info *CallInfo, p interface{}, stack []byte) {
+		panic("handler failed")
+	})
+	err = client.Call(context.Background(), "Arith.Panic", &ArithArgs{}, &reply)
+	_assert(ErrorCode(err) == CodeInternal, "expect Internal when the panic handler panics, got %v", err)
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(err == nil && reply == 3, "server should survive a panicking panic handler, got %v", err)
+}
+
+func TestErrorCodes(t *testing.T) {
+	_, addr := newTestServer(t)
+	for typ := range codec.NewCodecFuncMap {
+		client := dialTest(t, addr, &Option{CodecType: typ})
+		ctx := context.Background()
+		var reply int
+		err := client.Call(ctx, "Arith.Missing", &ArithArgs{}, &reply)
+		_assert(errors.Is(err, &Error{Code: CodeNotFound}), "%s: expect NotFound, got %v", typ, err)
+		err = client.Call(ctx, "Arith.Div", &ArithArgs{A: 1}, &reply)
+		var e *Error
+		_assert(errors.As(err, &e) && e.Code == CodeInvalidArgument && e.Details["field"] == "B", "%s: expect InvalidArgument with details, got %#v", typ, err)
+		err = client.Call(ctx, "Arith.Div", &ArithArgs{A: -1, B: 1}, &reply)
+		_assert(ErrorCode(err) == CodeApplication && err.Error() == "negative dividend", "%s: expect Application, got %v", typ, err)
+		err = client.Call(ctx, "Arith.Panic", &ArithArgs{}, &reply)
+		_assert(ErrorCode(err) == CodeInternal, "%s: expect Internal, got %v", typ, err)
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		err = client.Call(timeout, "Arith.Sleep", &ArithArgs{A: 100}, &reply)
+		cancel()
+		_assert(ErrorCode(err) == CodeDeadlineExceeded && errors.Is(err, context.DeadlineExceeded), "%s: expect DeadlineExceeded, got %v", typ, err)
+	}
+
+	// 只有服务器在执行前拒绝的请求匹配 ErrServerShutdown，旧版本服务器没有错误码时才比较消息
+	var refused codec.Header
+	setError(&refused, errShutdownRefused, CodeUnknown)
+	for _, h := range []*codec.Header{&refused, {Error: ErrServerShutdown.Error()}} {
+		err := errorFromHeader(h)
+		_assert(errors.Is(err, ErrServerShutdown) && ErrorCode(err) == CodeUnavailable && err.(*Error).Details == nil,
+			"expect ErrServerShutdown for %+v, got %#v", h, err)
+	}
+	// 服务方法返回的错误不能伪造拒绝标记
+	var app codec.Header
+	setError(&app, &Error{Code: CodeUnavailable, Message: "gateway", Details: map[string]string{refusedDetail: "shutdown", "k": "v"}}, CodeApplication)
+	err := errorFromHeader(&app)
+	_assert(!errors.Is(err, ErrServerShutdown) && err.(*Error).Details["k"] == "v" && err.(*Error).Details[refusedDetail] == "",
+		"application error should not match ErrServerShutdown, got %#v", err)
+	err = errorFromHeader(&codec.Header{Error: ErrServerShutdown.Error(), Code: uint32(CodeApplication)})
+	_assert(!errors.Is(err, ErrServerShutdown), "application error should not match ErrServerShutdown")
+
+	// 服务方法执行后返回的 CodeUnavailable 不会被 XClient 重试
+	xc := NewXClient(xclient.NewMultiServerDiscovery([]string{"tcp@" + addr}), xclient.RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	before := atomic.LoadInt64(&gatewayCalls)
+	var reply int
+	err = xc.Call(context.Background(), "Arith.Gateway", &ArithArgs{}, &reply)
+	_assert(ErrorCode(err) == CodeUnavailable && !errors.Is(err, ErrServerShutdown), "expect Unavailable, got %v", err)
+	_assert(atomic.LoadInt64(&gatewayCalls)-before == 1, "expect the method to run once, ran %d times", atomic.LoadInt64(&gatewayCalls)-before)
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.MaxConnInflight = 1
+	server.RejectOnLimit = true
+	client := dialTest(t, addr, nil)
+	slow := client.Go("Arith.Sleep", &ArithArgs{A: 100}, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	var reply int
+	err := client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(ErrorCode(err) == CodeResourceExhausted, "expect ResourceExhausted, got %v", err)
+	_assert((<-slow.Done).Error == nil, "in-flight call should succeed")
+
+	// 暂停模式下超出限制的请求排队执行
+	server2, addr2 := newTestServer(t)
+	server2.MaxInflight = 1
+	client2 := dialTest(t, addr2, nil)
+	start := time.Now()
+	calls := []*Call{
+		client2.Go("Arith.Sleep", &ArithArgs{A: 50}, new(int), nil),
+		client2.Go("Arith.Sleep", &ArithArgs{A: 50}, new(int), nil),
+	}
+	for _, call := range calls {
+		_assert((<-call.Done).Error == nil, "queued call should succeed")
+	}
+	_assert(time.Since(start) >= 100*time.Millisecond, "calls should run one at a time")
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.MaxRequestSize = 1024
+	big := strings.Repeat("a", 4096)
+	var reply string
+	for typ := range codec.NewCodecFuncMap {
+		for _, opt := range []*Option{
+			{CodecType: typ},
+			{CodecType: typ, Framed: true},
+			{CodecType: typ, Compression: codec.CompressGzip},
+		} {
+			client := dialTest(t, addr, opt)
+			err := client.Call(context.Background(), "Arith.Echo", big, &reply)
+			_assert(ErrorCode(err) == CodeResourceExhausted, "%+v: expect ResourceExhausted, got %v", opt, err)
+			if opt.Framed || opt.Compression != codec.CompressNone {
+				// 帧格式下超限的消息体被丢弃，连接仍然可用
+				err = client.Call(context.Background(), "Arith.Echo", "gee", &reply)
+				_assert(err == nil && reply == "gee", "%+v: connection should survive, got %v", opt, err)
+			}
+		}
+	}
+
+	// 客户端限制响应的大小
+	_, addr2 := newTestServer(t)
+	client := dialTest(t, addr2, &Option{Framed: true, MaxResponseSize: 1024})
+	err := client.Call(context.Background(), "Arith.Echo", big, &reply)
+	_assert(ErrorCode(err) == CodeResourceExhausted, "expect ResourceExhausted for large reply, got %v", err)
+	err = client.Call(context.Background(), "Arith.Echo", "gee", &reply)
+	_assert(err == nil && reply == "gee", "client should survive a large reply, got %v", err)
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	var reply int
+	err := client.Call(context.Background(), "Arith.Remaining", &ArithArgs{}, &reply)
+	_assert(err == nil && reply == -1, "expect no deadline, got %d %v", reply, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Call(ctx, "Arith.Remaining", &ArithArgs{}, &reply)
+	_assert(err == nil && reply > 0 && reply <= 1000, "expect inherited deadline, got %d %v", reply, err)
+
+	// 调用方的截止时间到期时服务器取消服务方法
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.Call(ctx, "Arith.Wait", &ArithArgs{}, &reply)
+	_assert(ErrorCode(err) == CodeDeadlineExceeded, "expect DeadlineExceeded, got %v", err)
+	select {
+	case err = <-waitCanceled:
+		_assert(err == context.DeadlineExceeded, "expect deadline exceeded, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("method context was not cancelled at the caller's deadline")
+	}
+
+	// 到达时已经过期的请求不会被执行
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	_assert(json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, CodecType: codec.GobType}) == nil, "send option")
+	cc := codec.NewGobCodec(conn)
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Arith.Add", Seq: 1, Timeout: -1}, &ArithArgs{A: 1, B: 2}) == nil, "send request")
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(nil) == nil, "read response")
+	_assert(Code(h.Code) == CodeDeadlineExceeded, "expect expired request rejected, got %+v", h)
+}
+
+func TestClientCancel(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	var reply int
+	err := client.Call(ctx, "Arith.Wait", &ArithArgs{}, &reply)
+	_assert(ErrorCode(err) == CodeCanceled, "expect Canceled, got %v", err)
+	select {
+	case err = <-waitCanceled:
+		_assert(err == context.Canceled, "expect canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("method context was not cancelled by the client")
+	}
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 1}, &reply)
+	_assert(err == nil && reply == 2, "call after cancel failed: %v", err)
+}
+
+func TestKeepalive(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.KeepaliveInterval = 20 * time.Millisecond
+	server.KeepaliveTimeout = 100 * time.Millisecond
+	client := dialTest(t, addr, &Option{KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 100 * time.Millisecond})
+	time.Sleep(300 * time.Millisecond)
+	var reply int
+	err := client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(client.IsAvailable() && err == nil && reply == 3, "healthy connection should stay open, got %v", err)
+
+	// 对端完成握手后不再回复 pong
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		var opt Option
+		_ = json.NewDecoder(conn).Decode(&opt)
+		_ = json.NewEncoder(conn).Encode(&Handshake{Version: ProtocolVersion, CodecType: opt.CodecType})
+		time.Sleep(time.Second)
+	}()
+	silent := dialTest(t, l.Addr().String(), &Option{KeepaliveInterval: 20 * time.Millisecond, KeepaliveTimeout: 50 * time.Millisecond})
+	time.Sleep(200 * time.Millisecond)
+	_assert(!silent.IsAvailable(), "client should become unavailable when pongs stop")
+
+	// 服务器关闭不回复 pong 的连接
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	_assert(json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, Version: ProtocolVersion, CodecType: codec.GobType}) == nil, "send option")
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	_assert(err == nil, "server should close the connection, got %v", err)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.IdleTimeout = 100 * time.Millisecond
+	client := dialTest(t, addr, nil)
+	var reply int
+	err := client.Call(context.Background(), "Arith.Sleep", &ArithArgs{A: 150}, &reply)
+	_assert(err == nil, "a call longer than the idle timeout should succeed, got %v", err)
+	_assert(client.IsAvailable(), "connection was closed right after a call")
+	time.Sleep(300 * time.Millisecond)
+	_assert(!client.IsAvailable(), "idle connection should be closed")
+}
+
+func TestServerStreaming(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, &Option{StreamWindow: 4})
+	atomic.StoreInt64(&rangeSent, 0)
+	stream, err := client.NewStream(context.Background(), "Arith.Range", &ArithArgs{A: 0, B: 100}, new(int))
+	_assert(err == nil, "new stream: %v", err)
+	// 客户端不读取时服务器最多发送一个窗口的消息
+	time.Sleep(100 * time.Millisecond)
+	_assert(atomic.LoadInt64(&rangeSent) <= 4, "server ignored flow control, sent %d", atomic.LoadInt64(&rangeSent))
+	for want := 0; ; want++ {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			_assert(want == 100, "expect 100 messages, got %d", want)
+			break
+		}
+		_assert(err == nil && n == want, "expect %d, got %d %v", want, n, err)
+	}
+
+	stream, err = client.NewStream(context.Background(), "Arith.Range", &ArithArgs{A: -1}, new(int))
+	_assert(err == nil, "new stream: %v", err)
+	err = stream.Recv(new(int))
+	_assert(ErrorCode(err) == CodeInvalidArgument, "expect stream error, got %v", err)
+
+	// 取消 ctx 结束流
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = client.NewStream(ctx, "Arith.Range", &ArithArgs{A: 0, B: 1 << 30}, new(int))
+	_assert(err == nil, "new stream: %v", err)
+	_assert(stream.Recv(new(int)) == nil, "expect first message")
+	cancel()
+	for err = nil; err == nil; err = stream.Recv(new(int)) {
+	}
+	_assert(ErrorCode(err) == CodeCanceled, "expect Canceled, got %v", err)
+
+	var reply int
+	err = client.Call(context.Background(), "Arith.Range", &ArithArgs{A: 0, B: 1}, &reply)
+	_assert(ErrorCode(err) == CodeInvalidArgument, "expect streaming method rejected for Call, got %v", err)
+}
+
+func TestClientStreaming(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+
+	// 客户端流：发送的消息超过服务器的缓冲区，依赖窗口更新继续发送
+	stream, err := client.NewStream(context.Background(), "Arith.Sum", 0, new(int))
+	_assert(err == nil, "new stream: %v", err)
+	want := 0
+	for i := 1; i <= 3*defaultStreamWindow; i++ {
+		_assert(stream.Send(i) == nil, "send %d", i)
+		want += i
+	}
+	_assert(stream.CloseSend() == nil, "close send")
+	var sum int
+	_assert(stream.Recv(&sum) == nil && sum == want, "expect sum %d, got %d", want, sum)
+	_assert(stream.Recv(&sum) == io.EOF, "expect EOF after the reply")
+
+	// 双向流，同一个连接上的普通调用不受影响
+	stream, err = client.NewStream(context.Background(), "Arith.Double", 1, new(int))
+	_assert(err == nil, "new stream: %v", err)
+	for i := 1; i <= 10; i++ {
+		var n int
+		_assert(stream.Recv(&n) == nil && n == i*2, "expect %d, got %d", i*2, n)
+		var reply int
+		err := client.Call(context.Background(), "Arith.Add", &ArithArgs{A: i, B: 1}, &reply)
+		_assert(err == nil && reply == i+1, "call during stream: %d %v", reply, err)
+		_assert(stream.Send(i+1) == nil, "send %d", i+1)
+	}
+	_assert(stream.CloseSend() == nil, "close send")
+	_assert(stream.Send(1) != nil, "expect send after CloseSend to fail")
+	var n int
+	_assert(stream.Recv(&n) == nil && n == 22, "expect last reply 22, got %d", n)
+	_assert(stream.Recv(&n) == io.EOF, "expect EOF")
+	_assert(stream.Send(1) != nil, "expect send on finished stream to fail")
+}
+
+func TestReflection(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+
+	var services []ServiceDesc
+	err := client.Call(context.Background(), ReflectionService+".List", "", &services)
+	_assert(err == nil, "list services: %v", err)
+	_assert(len(services) == 3 && services[0].Name == "Arith" && services[2].Name == ReflectionService,
+		"unexpected services %+v", services)
+
+	methods := make(map[string]MethodDesc)
+	for _, m := range services[0].Methods {
+		methods[m.Name] = m
+	}
+	add := methods["Add"]
+	_assert(add.Arg.Kind == "struct" && add.Arg.Name == "test.ArithArgs", "unexpected arg %+v", add.Arg)
+	_assert(len(add.Arg.Fields) == 2 && add.Arg.Fields[0].Name == "A" && add.Arg.Fields[0].Type.Kind == "int",
+		"unexpected fields %+v", add.Arg.Fields)
+	_assert(add.Reply.Kind == "int" && !add.Stream, "unexpected reply %+v", add.Reply)
+	rng := methods["Range"]
+	_assert(rng.Stream && rng.Reply == nil && rng.Arg.Name == "test.ArithArgs", "unexpected stream method %+v", rng)
+
+	services = nil
+	err = client.Call(context.Background(), ReflectionService+".List", "Arith", &services)
+	_assert(err == nil && len(services) == 1, "list Arith: %v %+v", err, services)
+	err = client.Call(context.Background(), ReflectionService+".List", "Nope", &services)
+	_assert(ErrorCode(err) == CodeNotFound, "expect NotFound, got %v", err)
+
+	// 引用自身的切片和 map 类型只展开一层
+	list := describeType(reflect.TypeOf(recList(nil)), nil)
+	_assert(list.Elem != nil && list.Elem.Name == "test.recList" && list.Elem.Elem == nil, "unexpected recursive slice %+v", list)
+	m := describeType(reflect.TypeOf(recMap(nil)), nil)
+	_assert(m.Elem != nil && m.Elem.Name == "test.recMap" && m.Elem.Elem == nil, "unexpected recursive map %+v", m)
+}
+
+type recList []recList
+
+type recMap map[string]recMap
+
+func TestHealth(t *testing.T) {
+	server, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	check := HealthService + ".Check"
+
+	var status ServingStatus
+	err := client.Call(context.Background(), check, "", &status)
+	_assert(err == nil && status == StatusServing, "server status: %v %v", status, err)
+	err = client.Call(context.Background(), check, "Arith", &status)
+	_assert(err == nil && status == StatusServing, "Arith status: %v %v", status, err)
+	err = client.Call(context.Background(), check, "Nope", &status)
+	_assert(ErrorCode(err) == CodeNotFound, "expect NotFound, got %v", err)
+
+	watch, err := client.NewStream(context.Background(), HealthService+".Watch", "Arith", new(ServingStatus))
+	_assert(err == nil, "watch: %v", err)
+	_assert(watch.Recv(&status) == nil && status == StatusServing, "expect SERVING, got %v", status)
+
+	server.SetServingStatus("Arith", StatusNotServing)
+	_assert(watch.Recv(&status) == nil && status == StatusNotServing, "expect NOT_SERVING, got %v", status)
+	err = client.Call(context.Background(), check, "Arith", &status)
+	_assert(err == nil && status == StatusNotServing, "Arith status: %v %v", status, err)
+	server.SetServingStatus("Arith", StatusServing)
+	_assert(watch.Recv(&status) == nil && status == StatusServing, "expect SERVING, got %v", status)
+
+	// 关闭服务器时 Watch 发送 DRAINING 后结束，不会阻止关闭
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(ctx) }()
+	_assert(watch.Recv(&status) == nil && status == StatusDraining, "expect DRAINING, got %v", status)
+	_assert(watch.Recv(&status) == io.EOF, "expect watch to end")
+	_assert(<-done == nil, "shutdown blocked by watch")
+}
+
+// negArith 是未导出的类型，Add 返回和的相反数，用于测试 RegisterName 和 Replace
+type negArith int
+
+func (a negArith) Add(args ArithArgs, reply *int) error {
+	*reply = -(args.A + args.B)
+	return nil
+}
+
+// BadService 没有签名符合要求的方法
+type BadService int
+
+func (b BadService) NoReply(args int) error                 { return nil }
+func (b BadService) ValueReply(args int, reply int) error   { return nil }
+func (b BadService) NoError(args int, reply *int)           {}
+func (b BadService) Hidden(args negArith, reply *int) error { return nil }
+func (b BadService) TwoErrors(a int, r *int) (error, error) { return nil, nil }
+
+func TestRegisterName(t *testing.T) {
+	server, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	args := &ArithArgs{A: 1, B: 2}
+
+	var neg negArith
+	err := server.Register(&neg)
+	_assert(err != nil && strings.Contains(err.Error(), "not exported"), "expect unexported type rejected, got %v", err)
+	err = server.Register(new(BadService))
+	_assert(err != nil, "expect type without suitable methods rejected")
+	for _, want := range []string{"NoReply: want arguments", "ValueReply: reply type int is not a pointer",
+		"NoError: must return a single error", "Hidden: args type test.negArith is not exported", "TwoErrors: must return"} {
+		_assert(strings.Contains(err.Error(), want), "expect %q in %v", want, err)
+	}
+	_assert(server.RegisterName("", &neg) != nil, "expect empty name rejected")
+	_assert(server.RegisterName("_Neg", &neg) != nil, "expect reserved name rejected")
+	_assert(server.RegisterName("Arith", &neg) != nil, "expect duplicate rejected")
+
+	_assert(server.RegisterName("Neg", &neg) == nil, "register Neg")
+	var reply int
+	err = client.Call(context.Background(), "Neg.Add", args, &reply)
+	_assert(err == nil && reply == -3, "Neg.Add: %d %v", reply, err)
+
+	// 替换时正在处理的调用使用原来的实现，之后的调用使用新的实现
+	slow := client.Go("Arith.Sleep", &ArithArgs{A: 100, B: 1}, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	_assert(server.Replace("Arith", &neg) == nil, "replace Arith")
+	err = client.Call(context.Background(), "Arith.Add", args, &reply)
+	_assert(err == nil && reply == -3, "expect replaced Arith.Add, got %d %v", reply, err)
+	<-slow.Done
+	_assert(slow.Error == nil && *slow.Reply.(*int) == 101, "in-flight call: %v", slow.Error)
+	_assert(server.Replace("Nope", &neg) != nil, "expect replacing missing service to fail")
+
+	_assert(server.Unregister("Neg") == nil, "unregister Neg")
+	err = client.Call(context.Background(), "Neg.Add", args, &reply)
+	_assert(ErrorCode(err) == CodeNotFound, "expect NotFound after unregister, got %v", err)
+	_assert(server.Unregister("Neg") != nil, "expect unregistering twice to fail")
+	_assert(server.RegisterName("Neg", &neg) == nil, "register Neg again")
+
+	// 被跳过的方法通过反射服务和调试页面报告
+	_assert(server.RegisterName("Partial", new(partial)) == nil, "register Partial")
+	var services []ServiceDesc
+	err = client.Call(context.Background(), ReflectionService+".List", "Partial", &services)
+	_assert(err == nil && len(services) == 1 && len(services[0].Methods) == 1, "list Partial: %v %+v", err, services)
+	_assert(strings.HasPrefix(services[0].Skipped["Broken"], "want arguments"), "expect Broken reported, got %+v", services[0].Skipped)
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/geerpc", nil))
+	_assert(strings.Contains(w.Body.String(), "Broken"), "expect Broken on the debug page")
+}
+
+// partial 只有一个签名符合要求的方法
+type partial int
+
+func (p partial) Add(args ArithArgs, reply *int) error { return nil }
+func (p partial) Broken(args int) error                { return nil }
+
+func TestOneWay(t *testing.T) {
+	server, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+
+	err := client.OneWay(context.Background(), "Arith.Notify", "hello")
+	_assert(err == nil, "one-way call: %v", err)
+	select {
+	case got := <-notified:
+		_assert(got == "hello", "unexpected argument %q", got)
+	case <-time.After(time.Second):
+		t.Fatal("one-way call was not executed")
+	}
+
+	// 失败的单向调用不发送响应，只在服务端计数
+	_assert(client.OneWay(context.Background(), "Arith.Div", &ArithArgs{A: 1}) == nil, "one-way Div")
+	_assert(client.OneWay(context.Background(), "Arith.Range", &ArithArgs{A: 1}) == nil, "one-way Range")
+	_assert(client.OneWay(context.Background(), "Arith.Nope", &ArithArgs{}) == nil, "one-way unknown method")
+	var reply int
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(err == nil && reply == 3, "call after one-way calls: %d %v", reply, err)
+	_, div, _ := server.findService("Arith.Div")
+	_, rng, _ := server.findService("Arith.Range")
+	// Div 在单独的 Goroutine 中执行，可能晚于 Add 完成
+	for i := 0; i < 100 && div.NumOneWayErrors() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(div.NumOneWayErrors() == 1 && rng.NumOneWayErrors() == 1,
+		"expect one-way errors counted, got %d %d", div.NumOneWayErrors(), rng.NumOneWayErrors())
+	client.mu.Lock()
+	pending := len(client.pending)
+	client.mu.Unlock()
+	_assert(pending == 0, "one-way calls must not stay pending, got %d", pending)
+}
+
+func TestBatch(t *testing.T) {
+	server, addr := newTestServer(t)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		client := dialTest(t, addr, &Option{CodecType: typ})
+		var calls []*Call
+		for i := 0; i < 5; i++ {
+			calls = append(calls, &Call{ServiceMethod: "Arith.Sleep", Args: &ArithArgs{A: 100, B: i}, Reply: new(int)})
+		}
+		calls = append(calls,
+			&Call{ServiceMethod: "Arith.Div", Args: &ArithArgs{A: 1}, Reply: new(int)},
+			&Call{ServiceMethod: "Arith.Nope", Args: &ArithArgs{}, Reply: new(int)},
+			&Call{ServiceMethod: "Arith.Range", Args: &ArithArgs{B: 1}, Reply: new(int)},
+			&Call{ServiceMethod: "Arith.Caller", Args: &ArithArgs{}, Reply: new(string)},
+		)
+		ctx := NewOutgoingContext(context.Background(), Pairs("caller", "tester", "trace-id", "t-1"))
+		start := time.Now()
+		err := client.Batch(ctx, calls)
+		_assert(err == nil, "%s: batch: %v", typ, err)
+		// 5 个 Sleep 并发执行
+		_assert(time.Since(start) < 300*time.Millisecond, "%s: batch calls were not run concurrently", typ)
+		for i := 0; i < 5; i++ {
+			_assert(calls[i].Error == nil && *calls[i].Reply.(*int) == 100+i, "%s: Sleep %d: %v", typ, i, calls[i].Error)
+		}
+		var e *Error
+		_assert(errors.As(calls[5].Error, &e) && e.Code == CodeInvalidArgument && e.Details["field"] == "B",
+			"%s: expect Div error with details, got %v", typ, calls[5].Error)
+		_assert(ErrorCode(calls[6].Error) == CodeNotFound, "%s: expect NotFound, got %v", typ, calls[6].Error)
+		_assert(ErrorCode(calls[7].Error) == CodeInvalidArgument, "%s: expect streaming method rejected, got %v", typ, calls[7].Error)
+		_assert(calls[8].Error == nil && *calls[8].Reply.(*string) == "tester", "%s: Caller: %v", typ, calls[8].Error)
+		_assert(calls[8].ReplyMetadata["trace-id"] == "t-1", "%s: expect reply metadata, got %v", typ, calls[8].ReplyMetadata)
+	}
+
+	// 同时执行的调用数有上限，超过 MaxBatchSize 的批量调用被拒绝
+	server.MaxBatchSize = 3 * batchConcurrency
+	client := dialTest(t, addr, nil)
+	calls := make([]*Call, server.MaxBatchSize)
+	for i := range calls {
+		calls[i] = &Call{ServiceMethod: "Arith.Sleep", Args: &ArithArgs{A: 20}, Reply: new(int)}
+	}
+	start := time.Now()
+	err := client.Batch(context.Background(), calls)
+	_assert(err == nil && calls[len(calls)-1].Error == nil, "batch: %v", err)
+	_assert(time.Since(start) >= 60*time.Millisecond, "expect at most %d calls at a time", batchConcurrency)
+	calls = append(calls, &Call{ServiceMethod: "Arith.Add", Args: &ArithArgs{}, Reply: new(int)})
+	err = client.Batch(context.Background(), calls)
+	_assert(ErrorCode(err) == CodeResourceExhausted, "expect ResourceExhausted for an oversized batch, got %v", err)
+
+	// 批量调用中的调用同样受连接的名额限制
+	server2, addr2 := newTestServer(t)
+	server2.MaxConnInflight = 2
+	server2.RejectOnLimit = true
+	client2 := dialTest(t, addr2, nil)
+	calls = make([]*Call, 4)
+	for i := range calls {
+		calls[i] = &Call{ServiceMethod: "Arith.Sleep", Args: &ArithArgs{A: 50}, Reply: new(int)}
+	}
+	start = time.Now()
+	batchDone := make(chan error, 1)
+	go func() { batchDone <- client2.Batch(context.Background(), calls) }()
+	time.Sleep(20 * time.Millisecond)
+	var reply int
+	err = client2.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &reply)
+	_assert(ErrorCode(err) == CodeResourceExhausted, "expect the batch to hold all slots, got %v", err)
+	_assert(<-batchDone == nil && calls[3].Error == nil, "limited batch: %v", calls[3].Error)
+	_assert(time.Since(start) >= 100*time.Millisecond, "expect at most %d calls at a time", server2.MaxConnInflight)
+}
+
+// Agent 是注册在客户端上、由服务器反向调用的服务
+type Agent struct {
+	name string
+}
+
+func (a *Agent) Hostname(args string, reply *string) error {
+	*reply = a.name + ":" + args
+	return nil
+}
+
+func (a *Agent) Fail(args string, reply *string) error {
+	return Errorf(CodeUnavailable, "agent busy")
+}
+
+func TestReverseCall(t *testing.T) {
+	_, addr := newTestServer(t)
+	client := dialTest(t, addr, nil)
+	_assert(client.Register(&Agent{name: "agent-1"}) == nil, "register Agent")
+	_assert(client.Register(&Agent{}) != nil, "expect duplicate service rejected")
+
+	var reply string
+	err := client.Call(context.Background(), "Arith.Callback", "Agent.Hostname", &reply)
+	_assert(err == nil && reply == "agent-1:ping", "callback: %q %v", reply, err)
+	err = client.Call(context.Background(), "Arith.Callback", "Agent.Fail", &reply)
+	_assert(ErrorCode(err) == CodeUnavailable, "expect agent error, got %v", err)
+	err = client.Call(context.Background(), "Arith.Callback", "Agent.Nope", &reply)
+	_assert(ErrorCode(err) == CodeNotFound, "expect NotFound, got %v", err)
+
+	// 句柄在服务方法返回后仍然可以使用，普通调用与反向调用复用同一个连接
+	conn := <-callbacks
+	err = conn.Call(context.Background(), "Agent.Hostname", "later", &reply)
+	_assert(err == nil && reply == "agent-1:later", "call after handler returned: %q %v", reply, err)
+	var sum int
+	err = client.Call(context.Background(), "Arith.Add", &ArithArgs{A: 1, B: 2}, &sum)
+	_assert(err == nil && sum == 3, "call: %d %v", sum, err)
+
+	_ = client.Close()
+	for i := 0; i < 100 && err == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		err = conn.Call(context.Background(), "Agent.Hostname", "closed", &reply)
+	}
+	_assert(ErrorCode(err) == CodeUnavailable, "expect Unavailable after the client closed, got %v", err)
+}
+
+func TestPauseWithStreams(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.MaxConnInflight = 1
+	client := dialTest(t, addr, nil)
+
+	// 流占用唯一的名额时后来的请求排队，读取循环仍然接收流的后续消息
+	stream, err := client.NewStream(context.Background(), "Arith.Sum", 1, new(int))
+	_assert(err == nil, "new stream: %v", err)
+	time.Sleep(20 * time.Millisecond)
+	queued := client.Go("Arith.Add", &ArithArgs{A: 1, B: 2}, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	_assert(stream.Send(2) == nil && stream.CloseSend() == nil, "send to stream")
+	var sum int
+	_assert(stream.Recv(&sum) == nil && sum == 3, "expect sum 3, got %d", sum)
+	_assert(stream.Recv(&sum) == io.EOF, "expect EOF")
+	_assert((<-queued.Done).Error == nil && *queued.Reply.(*int) == 3, "queued call after the stream: %v", queued.Error)
+}
+
+func TestPauseWithReverseCalls(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.MaxConnInflight = 1
+	client := dialTest(t, addr, nil)
+	_assert(client.Register(&Agent{name: "agent-2"}) == nil, "register Agent")
+
+	// 请求排队等待名额时发起的反向调用同样能收到响应
+	select {
+	case <-callbacks:
+	default:
+	}
+	var s string
+	err := client.Call(context.Background(), "Arith.Callback", "Agent.Hostname", &s)
+	_assert(err == nil && s == "agent-2:ping", "callback: %q %v", s, err)
+	conn := <-callbacks
+	slow := client.Go("Arith.Sleep", &ArithArgs{A: 200}, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	queued := client.Go("Arith.Add", &ArithArgs{A: 1, B: 2}, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = conn.Call(ctx, "Agent.Hostname", "paused", &s)
+	_assert(err == nil && s == "agent-2:paused", "reverse call while paused: %q %v", s, err)
+	_assert((<-slow.Done).Error == nil, "slow call should succeed")
+	_assert((<-queued.Done).Error == nil, "queued call should succeed")
+}
+
+func TestPauseWithCancel(t *testing.T) {
+	server, addr := newTestServer(t)
+	server.MaxConnInflight = 1
+	client := dialTest(t, addr, nil)
+
+	// 唯一的名额被等待取消的调用占用时，读取循环仍然能读到取消消息
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() { canceled <- client.Call(ctx, "Arith.Wait", &ArithArgs{}, new(int)) }()
+	time.Sleep(20 * time.Millisecond)
+	queued := client.Go("Arith.Add", &ArithArgs{A: 1, B: 2}, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	_assert(ErrorCode(<-canceled) == CodeCanceled, "expect Canceled")
+	select {
+	case err := <-waitCanceled:
+		_assert(err == context.Canceled, "expect canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("method context was not cancelled while another call was queued")
+	}
+	select {
+	case <-queued.Done:
+		_assert(queued.Error == nil && *queued.Reply.(*int) == 3, "queued call: %v", queued.Error)
+	case <-time.After(time.Second):
+		t.Fatal("queued call did not run after the cancel freed the slot")
+	}
+}
@@ -1,24 +1,41 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync/atomic"
 )
 
 // methodType 结构体代表一个服务方法的类型
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64
+	method          reflect.Method
+	HasContext      bool // 方法的第一个参数是否为 context.Context
+	Stream          bool // 方法的第二个参数是否为 *ServerStream
+	ArgType         reflect.Type
+	ReplyType       reflect.Type
+	numCalls        uint64
+	numPanics       uint64 // 调用中发生并被恢复的 panic 次数
+	numOneWayErrors uint64 // 失败的单向调用次数，单向调用的错误不会返回给客户端
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+func (m *methodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
+func (m *methodType) NumOneWayErrors() uint64 {
+	return atomic.LoadUint64(&m.numOneWayErrors)
+}
+
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
 	// 参数可能是指针类型，也可能是值类型
@@ -42,64 +59,131 @@ func (m *methodType) newReplyv() reflect.Value {
 	return replyv
 }
 
+var (
+	typeOfError        = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext      = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+)
+
 // service 结构体代表一个服务
 type service struct {
-	name   string
-	typ    reflect.Type
-	rcvr   reflect.Value
-	method map[string]*methodType
+	name    string
+	typ     reflect.Type
+	rcvr    reflect.Value
+	method  map[string]*methodType
+	skipped map[string]string // 签名不符合要求而没有注册的导出方法，值为原因
 }
 
-// newService 函数创建一个新的 service 实例
-func newService(rcvr interface{}) *service {
+// newService 函数创建一个名为 name 的 service 实例，name 为空时使用 rcvr 的类型名，此时类型必须是导出的。
+// 没有任何方法可以注册时返回错误，错误中列出每个方法被跳过的原因
+func newService(name string, rcvr interface{}) (*service, error) {
+	if rcvr == nil {
+		return nil, errors.New("rpc server: service receiver is nil")
+	}
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server: %s is not a valid service name", s.name)
+	if name == "" {
+		name = reflect.Indirect(s.rcvr).Type().Name()
+		if !ast.IsExported(name) {
+			return nil, fmt.Errorf("rpc server: type %s is not exported, use RegisterName to name the service", s.typ)
+		}
 	}
+	s.name = name
 	s.registerMethods()
-	return s
+	if len(s.method) == 0 {
+		return nil, fmt.Errorf("rpc server: type %s has no methods of suitable type%s", s.typ, s.skippedReport())
+	}
+	return s, nil
 }
 
-// registerMethods 函数注册服务类型中的所有导出方法
+// registerMethods 函数注册服务类型中的所有导出方法，签名不符合要求的方法记录在 skipped 中
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
+	s.skipped = make(map[string]string)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
-		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		mtype, reason := newMethodType(method)
+		if mtype == nil {
+			s.skipped[method.Name] = reason
+			log.Printf("rpc server: skip %s.%s: %s\n", s.name, method.Name, reason)
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
-		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
+		s.method[method.Name] = mtype
 		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
 
+// newMethodType 检查方法的签名，不符合要求时返回 nil 和原因。
+// 支持 func(args, reply) error 和 func(ctx context.Context, args, reply) error 两种形式，
+// reply 为 *ServerStream 的是流式方法，args 是客户端第一条消息的类型，
+// 客户端之后通过流发送的消息也按这个类型解码
+func newMethodType(method reflect.Method) (*methodType, string) {
+	mType := method.Type
+	hasContext := mType.NumIn() == 4 && mType.In(1) == typeOfContext
+	if mType.NumIn() != 3 && !hasContext {
+		return nil, "want arguments (args, reply) or (context.Context, args, reply)"
+	}
+	if mType.NumOut() != 1 || mType.Out(0) != typeOfError {
+		return nil, "must return a single error"
+	}
+	argType, replyType := mType.In(mType.NumIn()-2), mType.In(mType.NumIn()-1)
+	if !isExportedOrBuiltinType(argType) {
+		return nil, fmt.Sprintf("args type %s is not exported", argType)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return nil, fmt.Sprintf("reply type %s is not a pointer", replyType)
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return nil, fmt.Sprintf("reply type %s is not exported", replyType)
+	}
+	return &methodType{
+		method:     method,
+		HasContext: hasContext,
+		Stream:     replyType == typeOfServerStream,
+		ArgType:    argType,
+		ReplyType:  replyType,
+	}, ""
+}
+
+// skippedReport 按方法名列出被跳过的方法及原因
+func (s *service) skippedReport() string {
+	names := make([]string, 0, len(s.skipped))
+	for name := range s.skipped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "; %s: %s", name, s.skipped[name])
+	}
+	return b.String()
+}
+
 // call 函数调用服务方法
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext 函数调用服务方法，方法接受 context.Context 时传入 ctx
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.HasContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
 	return nil
 }
 
-// isExportedOrBuiltinType 函数检查类型是否是导出的或内置的
+// isExportedOrBuiltinType 函数检查类型是否是导出的或内置的，指针检查它指向的类型
 func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
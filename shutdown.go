@@ -0,0 +1,138 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrServerShutdown 表示服务器正在关闭，请求没有被执行，可以安全地重试到其他服务器
+var ErrServerShutdown = errors.New("rpc: server is shutting down")
+
+// errShutdownRefused 是服务器关闭期间在执行前拒绝请求时发送给客户端的错误，refusedDetail 表示请求没有被执行
+var errShutdownRefused = &Error{
+	Code:    CodeUnavailable,
+	Message: ErrServerShutdown.Error(),
+	Details: map[string]string{refusedDetail: "shutdown"},
+	cause:   ErrServerShutdown,
+}
+
+// errServerShutdown 是客户端在服务器关闭后拒绝新请求时返回的错误，它的错误码为 CodeUnavailable 并匹配 ErrServerShutdown
+var errServerShutdown = &Error{Code: CodeUnavailable, Message: ErrServerShutdown.Error(), cause: ErrServerShutdown}
+
+func (server *Server) shuttingDown() bool {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+	return server.inShutdown
+}
+
+// trackListener 记录或移除正在 Accept 的监听器，服务器关闭后不再接受新的监听器
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 记录或移除正在服务的连接，服务器关闭后不再接受新的连接
+func (server *Server) trackConn(sc *serverConn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.conns, sc)
+		return true
+	}
+	if server.inShutdown {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[*serverConn]struct{})
+	}
+	server.conns[sc] = struct{}{}
+	return true
+}
+
+// Shutdown 优雅地关闭服务器：停止接受新连接，通知已连接的客户端不再接受新的请求，
+// 等待正在处理的请求完成后关闭连接。ctx 到期时强制关闭所有连接并返回 ctx.Err()
+func (server *Server) Shutdown(ctx context.Context) error {
+	// 结束 _Health.Watch，它们不应阻止连接关闭
+	server.SetServingStatus("", StatusDraining)
+	server.mu.Lock()
+	server.inShutdown = true
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	conns := make([]*serverConn, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+	}
+	server.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.drain()
+	}
+	for _, sc := range conns {
+		select {
+		case <-sc.idle:
+			_ = sc.cc.Close()
+		case <-ctx.Done():
+			for _, sc := range conns {
+				_ = sc.cc.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// begin 记录一个新的请求，连接正在关闭时返回 false
+func (sc *serverConn) begin() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.draining {
+		return false
+	}
+	sc.inflight++
+	sc.lastActive = time.Now()
+	sc.wg.Add(1)
+	return true
+}
+
+// end 记录一个请求处理完成
+func (sc *serverConn) end() {
+	sc.mu.Lock()
+	sc.inflight--
+	sc.lastActive = time.Now()
+	if sc.draining && sc.inflight == 0 {
+		close(sc.idle)
+	}
+	sc.mu.Unlock()
+	sc.wg.Done()
+}
+
+// drain 停止接受新的请求，并通知客户端服务器正在关闭
+func (sc *serverConn) drain() {
+	sc.mu.Lock()
+	if sc.draining {
+		sc.mu.Unlock()
+		return
+	}
+	sc.draining = true
+	if sc.inflight == 0 {
+		close(sc.idle)
+	}
+	sc.mu.Unlock()
+	sc.writeControl(&codec.Header{Kind: codec.KindGoAway})
+}
@@ -0,0 +1,162 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"errors"
+	"fmt"
+)
+
+// Code 是随响应传输的错误码，取值与 gRPC 的状态码保持一致
+type Code uint32
+
+const (
+	CodeOK                Code = 0
+	CodeCanceled          Code = 1  // 调用被调用方取消
+	CodeUnknown           Code = 2  // 未知错误，例如旧版本服务器只返回了错误字符串
+	CodeInvalidArgument   Code = 3  // 请求格式错误或参数无法解码
+	CodeDeadlineExceeded  Code = 4  // 调用超时
+	CodeNotFound          Code = 5  // 服务或方法不存在
+	CodeResourceExhausted Code = 8  // 服务器资源不足，请求被拒绝
+	CodeInternal          Code = 13 // 服务器内部错误，例如服务方法 panic
+	CodeUnavailable       Code = 14 // 服务器不可用，通常可以重试
+	CodeApplication       Code = 100
+	// 服务方法返回的普通错误使用 CodeApplication，大于它的错误码留给应用自定义
+)
+
+var codeNames = map[Code]string{
+	CodeOK:                "OK",
+	CodeCanceled:          "Canceled",
+	CodeUnknown:           "Unknown",
+	CodeInvalidArgument:   "InvalidArgument",
+	CodeDeadlineExceeded:  "DeadlineExceeded",
+	CodeNotFound:          "NotFound",
+	CodeResourceExhausted: "ResourceExhausted",
+	CodeInternal:          "Internal",
+	CodeUnavailable:       "Unavailable",
+	CodeApplication:       "Application",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// Error 是带错误码的 RPC 错误。服务方法返回 *Error 可以指定客户端收到的错误码和详情，
+// 客户端收到的错误响应也总是 *Error，可以用 errors.As 取出错误码
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string // 可选的错误详情
+	cause   error             // 客户端本地产生的错误的原因，不会被传输
+}
+
+// Errorf 创建一个带错误码的错误
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is 使 errors.Is(err, &Error{Code: c}) 按错误码匹配，target 带有 Message 时还要求消息相同
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// ErrorCode 返回 err 的错误码，err 为 nil 时返回 CodeOK，不是 *Error 时返回 CodeUnknown
+func ErrorCode(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeUnknown
+}
+
+// contextCode 返回 context 错误对应的错误码
+func contextCode(err error) Code {
+	if err == context.DeadlineExceeded {
+		return CodeDeadlineExceeded
+	}
+	return CodeCanceled
+}
+
+// toStatus 将服务端的错误转换为 *Error，无法识别的错误使用错误码 code
+func toStatus(err error, code Code) *Error {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ErrServerShutdown):
+		code = CodeUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		code = CodeDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = CodeCanceled
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
+
+// refusedDetail 是服务器在执行前拒绝请求时写入错误详情的键，客户端据此判断请求没有被执行、可以安全地重试。
+// 服务方法返回的错误中的同名详情会被删除，只有服务器自己能设置它
+const refusedDetail = "rpc-refused"
+
+// setError 把错误写入响应头，Error 字段保留错误消息以兼容只读取字符串的旧客户端
+func setError(h *codec.Header, err error, code Code) {
+	st := toStatus(err, code)
+	h.Error = st.Message
+	h.Code = uint32(st.Code)
+	h.Details = st.Details
+	if st != errShutdownRefused {
+		h.Details = withoutDetail(st.Details, refusedDetail)
+	}
+}
+
+// errorFromHeader 在客户端把错误响应转换为 *Error，服务器关闭时在执行前拒绝的请求的错误匹配 ErrServerShutdown
+func errorFromHeader(h *codec.Header) error {
+	code := Code(h.Code)
+	refused := code == CodeUnavailable && h.Details[refusedDetail] != ""
+	if code == CodeOK {
+		// 旧版本服务器没有错误码，只能根据消息识别服务器关闭
+		code = CodeUnknown
+		if h.Error == ErrServerShutdown.Error() {
+			code, refused = CodeUnavailable, true
+		}
+	}
+	e := &Error{Code: code, Message: h.Error, Details: withoutDetail(h.Details, refusedDetail)}
+	if refused {
+		e.cause = ErrServerShutdown
+	}
+	return e
+}
+
+// withoutDetail 返回删除了 key 的错误详情，details 中没有 key 时原样返回
+func withoutDetail(details map[string]string, key string) map[string]string {
+	if _, ok := details[key]; !ok {
+		return details
+	}
+	rest := make(map[string]string, len(details)-1)
+	for k, v := range details {
+		if k != key {
+			rest[k] = v
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
@@ -0,0 +1,341 @@
+package test
+
+import (
+	"context"
+	"distributed/codec"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// defaultStreamWindow 是未设置 Option.StreamWindow 时客户端为每个流缓冲的消息数，
+// 也是服务器为每个流缓冲的客户端消息数
+const defaultStreamWindow = 32
+
+// flowControl 记录还允许向对端发送的消息数，额度用尽时发送方等待对端的 KindWindowUpdate
+type flowControl struct {
+	mu     sync.Mutex
+	credit uint32
+	more   chan struct{} // 收到新的额度时通知等待的发送方
+}
+
+func newFlowControl(credit uint32) *flowControl {
+	return &flowControl{credit: credit, more: make(chan struct{}, 1)}
+}
+
+// take 取得一条消息的额度，done 关闭时返回 false
+func (f *flowControl) take(done <-chan struct{}) bool {
+	for {
+		f.mu.Lock()
+		if f.credit > 0 {
+			f.credit--
+			f.mu.Unlock()
+			return true
+		}
+		f.mu.Unlock()
+		select {
+		case <-f.more:
+		case <-done:
+			return false
+		}
+	}
+}
+
+// add 在对端读取了 n 条消息后增加额度
+func (f *flowControl) add(n uint32) {
+	f.mu.Lock()
+	f.credit += n
+	f.mu.Unlock()
+	select {
+	case f.more <- struct{}{}:
+	default:
+	}
+}
+
+// streamMsg 是接收循环读出的一条消息，err 非空表示消息无法解码
+type streamMsg struct {
+	v   reflect.Value
+	err error
+}
+
+// recvBuffer 缓冲流中收到的消息。消息由连接的接收循环解码后放入，
+// 对端遵守流控时缓冲区不会满，接收循环也就不会被一个读取缓慢的流阻塞
+type recvBuffer struct {
+	msgType reflect.Type // 消息的类型，读取时传入指向它的指针
+	msgs    chan streamMsg
+	window  uint32
+	unacked uint32 // 已经读取但还没有通知对端的消息数
+	closed  bool   // 对端不再发送，只由接收循环访问
+}
+
+func newRecvBuffer(msgType reflect.Type, window uint32) *recvBuffer {
+	return &recvBuffer{msgType: msgType, msgs: make(chan streamMsg, window), window: window}
+}
+
+// push 从 cc 中读取一条消息放入缓冲区，只由接收循环调用
+func (b *recvBuffer) push(cc codec.Codec) error {
+	if b.closed {
+		if err := cc.ReadBody(nil); err != nil && !isDecodeError(err) {
+			return err
+		}
+		return nil
+	}
+	v := reflect.New(b.msgType)
+	err := cc.ReadBody(v.Interface())
+	if err != nil && !isDecodeError(err) {
+		return err
+	}
+	if err != nil {
+		err = errors.New("reading body " + err.Error())
+	}
+	select {
+	case b.msgs <- streamMsg{v: v, err: err}:
+		return nil
+	default:
+		return errors.New("rpc: peer exceeded the stream window")
+	}
+}
+
+// close 在对端不再发送消息时关闭缓冲区，只由接收循环调用
+func (b *recvBuffer) close() {
+	if !b.closed {
+		b.closed = true
+		close(b.msgs)
+	}
+}
+
+// deliver 把消息写入 reply，返回应当通知对端的额度，读取了半个窗口的消息后才通知一次
+func (b *recvBuffer) deliver(m streamMsg, reply interface{}) (uint32, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	rv := reflect.ValueOf(reply)
+	if rv.Type() != m.v.Type() {
+		return 0, fmt.Errorf("rpc: stream message must be read into %s, got %s", m.v.Type(), rv.Type())
+	}
+	rv.Elem().Set(m.v.Elem())
+	if b.unacked++; b.unacked < (b.window+1)/2 {
+		return 0, nil
+	}
+	n := b.unacked
+	b.unacked = 0
+	return n, nil
+}
+
+// ServerStream 是流式调用的服务端。以 func(args, stream *ServerStream) error 的形式声明的方法是流式方法：
+// args 是客户端发送的第一条消息，之后客户端通过 ClientStream.Send 发送的消息与它类型相同，由 Recv 读取；
+// 方法通过 Send 向客户端发送任意多条消息，方法返回时流结束，返回的错误会被发送给客户端
+type ServerStream struct {
+	ctx  context.Context
+	sc   *serverConn
+	seq  uint64
+	send *flowControl
+	recv *recvBuffer
+}
+
+func newServerStream(sc *serverConn, req *request) *ServerStream {
+	msgType := req.mtype.ArgType
+	if msgType.Kind() == reflect.Ptr {
+		msgType = msgType.Elem()
+	}
+	return &ServerStream{
+		sc:   sc,
+		seq:  req.h.Seq,
+		send: newFlowControl(req.h.Window),
+		recv: newRecvBuffer(msgType, defaultStreamWindow),
+	}
+}
+
+// Context 返回本次调用的 context，客户端取消调用或流结束后它会被取消
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send 向客户端发送一条消息。客户端的缓冲区已满时阻塞，直到客户端读取了消息或调用被取消
+func (s *ServerStream) Send(v interface{}) error {
+	if !s.send.take(s.ctx.Done()) {
+		return s.ctx.Err()
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	s.sc.sending.Lock()
+	defer s.sc.sending.Unlock()
+	return s.sc.cc.Write(&codec.Header{Kind: codec.KindStreamMsg, Seq: s.seq}, v)
+}
+
+// Recv 把客户端发送的下一条消息写入 v，v 必须是指向参数类型的指针。
+// 客户端调用 CloseSend 后返回 io.EOF。Recv 不能被多个 Goroutine 同时调用
+func (s *ServerStream) Recv(v interface{}) error {
+	select {
+	case m, ok := <-s.recv.msgs:
+		if !ok {
+			return io.EOF
+		}
+		n, err := s.recv.deliver(m, v)
+		if n > 0 {
+			s.sc.writeControl(&codec.Header{Kind: codec.KindWindowUpdate, Seq: s.seq, Window: n})
+		}
+		return err
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// receiveStream 在读取循环中把客户端发送的消息读入对应的流
+func (sc *serverConn) receiveStream(h *codec.Header) error {
+	call := sc.lookup(h.Seq)
+	if call == nil || call.stream == nil {
+		// 调用已经结束，丢弃消息
+		if err := sc.cc.ReadBody(nil); err != nil && !isDecodeError(err) {
+			return err
+		}
+		return nil
+	}
+	return call.stream.recv.push(sc.cc)
+}
+
+// ClientStream 是流式调用的客户端。Recv 按服务器发送的顺序返回消息，服务器最多比客户端多发送
+// StreamWindow 条消息；Send 向服务器发送消息，同样受服务器缓冲区大小的限制
+type ClientStream struct {
+	client     *Client
+	call       *Call
+	ctx        context.Context
+	send       *flowControl
+	recv       *recvBuffer
+	sendClosed bool
+	done       bool          // 已经收到结束流的响应
+	finished   chan struct{} // 调用结束时关闭
+	stop       func() bool
+}
+
+// NewStream 发起一个流式调用。args 是发送给服务器的第一条消息，reply 是指向服务器消息类型的指针，
+// 只用于确定消息的类型。服务端流式调用只需要 Recv；客户端流式和双向流式调用通过 Send 发送更多消息，
+// 并在发送完毕后调用 CloseSend。取消 ctx 会通知服务器停止处理，ctx 的截止时间和请求元数据与 Call 一样发送给服务器
+func (client *Client) NewStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	if client.handshake == nil || client.handshake.Version < 4 {
+		return nil, errors.New("rpc client: server does not support streaming")
+	}
+	t := reflect.TypeOf(reply)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, errors.New("rpc client: stream reply must be a pointer")
+	}
+	window := client.opt.StreamWindow
+	if window <= 0 {
+		window = defaultStreamWindow
+	}
+	s := &ClientStream{
+		client:   client,
+		ctx:      ctx,
+		send:     newFlowControl(defaultStreamWindow),
+		recv:     newRecvBuffer(t.Elem(), uint32(window)),
+		finished: make(chan struct{}),
+	}
+	md, _ := FromOutgoingContext(ctx)
+	deadline, _ := ctx.Deadline()
+	s.call = &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Metadata:      md,
+		Deadline:      deadline,
+		Done:          make(chan *Call, 1),
+		stream:        s,
+	}
+	client.send(s.call)
+	s.stop = context.AfterFunc(ctx, s.abandon)
+	return s, nil
+}
+
+// abandon 在 ctx 结束时结束调用，并通知服务器停止处理
+func (s *ClientStream) abandon() {
+	if s.client.removeCall(s.call.Seq) == nil {
+		return // 流已经结束
+	}
+	s.client.cancel(s.call.Seq)
+	err := s.ctx.Err()
+	s.call.Error = &Error{Code: contextCode(err), Message: "rpc client: stream canceled: " + err.Error(), cause: err}
+	s.call.done()
+}
+
+// errStreamFinished 表示流已经结束，不能再发送消息，结束的原因由 Recv 返回
+var errStreamFinished = errors.New("rpc client: stream finished")
+
+// Send 向服务器发送一条消息，消息的类型必须与 NewStream 的 args 相同。服务器的缓冲区已满时阻塞，
+// 流已经结束时返回错误，此时由 Recv 返回结束的原因。Send 不能被多个 Goroutine 同时调用
+func (s *ClientStream) Send(v interface{}) error {
+	if s.client.handshake.Version < 5 {
+		return errors.New("rpc client: server does not support client streaming")
+	}
+	if s.sendClosed {
+		return errors.New("rpc client: send on closed stream")
+	}
+	if !s.send.take(s.finished) {
+		return errStreamFinished
+	}
+	s.client.sending.Lock()
+	defer s.client.sending.Unlock()
+	return s.client.cc.Write(&codec.Header{Kind: codec.KindStreamMsg, Seq: s.call.Seq}, v)
+}
+
+// CloseSend 通知服务器不会再发送消息，服务器的 Recv 随后返回 io.EOF
+func (s *ClientStream) CloseSend() error {
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	select {
+	case <-s.finished:
+		return nil
+	default:
+	}
+	if s.client.handshake.Version >= 5 {
+		s.client.writeControl(&codec.Header{Kind: codec.KindCloseSend, Seq: s.call.Seq})
+	}
+	return nil
+}
+
+// Recv 把服务器发送的下一条消息写入 reply，reply 的类型必须与 NewStream 的 reply 相同。
+// 流正常结束时返回 io.EOF，服务器返回错误或调用被取消时返回对应的错误。Recv 不能被多个 Goroutine 同时调用
+func (s *ClientStream) Recv(reply interface{}) error {
+	if !s.done {
+		select {
+		case m := <-s.recv.msgs:
+			n, err := s.recv.deliver(m, reply)
+			if n > 0 {
+				s.client.writeControl(&codec.Header{Kind: codec.KindWindowUpdate, Seq: s.call.Seq, Window: n})
+			}
+			return err
+		case <-s.call.Done:
+			s.done = true
+			s.stop()
+			if p, ok := s.ctx.Value(replyCaptureKey{}).(*Metadata); ok {
+				*p = s.call.ReplyMetadata
+			}
+		}
+	}
+	// 结束流的响应之前到达的消息都已经在缓冲区中
+	select {
+	case m := <-s.recv.msgs:
+		_, err := s.recv.deliver(m, reply)
+		return err
+	default:
+	}
+	if s.call.Error != nil {
+		return s.call.Error
+	}
+	return io.EOF
+}
+
+// receiveStream 在接收循环中把服务器发送的消息读入对应的流
+func (client *Client) receiveStream(h *codec.Header) error {
+	client.mu.Lock()
+	call := client.pending[h.Seq]
+	client.mu.Unlock()
+	if call == nil || call.stream == nil {
+		// 流已经被取消
+		return client.cc.ReadBody(nil)
+	}
+	return call.stream.recv.push(client.cc)
+}
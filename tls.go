@@ -0,0 +1,87 @@
+package test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// Peer 描述一次调用的对端连接，服务方法可以通过 PeerFromContext 获取
+type Peer struct {
+	Addr net.Addr             // 对端地址，连接不支持 RemoteAddr 时为 nil
+	TLS  *tls.ConnectionState // 非 TLS 连接时为 nil
+}
+
+// Identity 返回经过校验的客户端证书的 CommonName，没有客户端证书时返回空字符串
+func (p *Peer) Identity() string {
+	if p == nil || p.TLS == nil || len(p.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	return p.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+type peerKey struct{}
+
+// PeerFromContext 在服务方法中返回发起调用的连接信息
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// newPeer 从连接中提取对端信息，TLS 连接会先完成握手
+func newPeer(conn io.ReadWriteCloser) (*Peer, error) {
+	p := new(Peer)
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		p.Addr = c.RemoteAddr()
+	}
+	if c, ok := conn.(*tls.Conn); ok {
+		if err := c.Handshake(); err != nil {
+			return nil, err
+		}
+		state := c.ConnectionState()
+		p.TLS = &state
+	}
+	return p, nil
+}
+
+// AcceptTLS 使用 config 在 lis 上接受 TLS 连接，
+// 设置 config.ClientAuth 和 config.ClientCAs 即可要求并校验客户端证书
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
+func AcceptTLS(lis net.Listener, config *tls.Config) {
+	DefaultServer.AcceptTLS(lis, config)
+}
+
+// newTLSClientFunc 返回一个在建立 RPC 客户端前先完成 TLS 握手的 newClientFunc
+func newTLSClientFunc(config *tls.Config, address string) newClientFunc {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		// 未指定 ServerName 时使用地址中的主机名校验服务器证书
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	return func(conn net.Conn, opt *Option) (*Client, error) {
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, err
+		}
+		return NewClient(tlsConn, opt)
+	}
+}
+
+// DialTLS 通过 TLS 连接到指定网络地址的 RPC 服务器，config 为 nil 时使用 Option.TLSConfig
+func DialTLS(network, address string, config *tls.Config, opts ...*Option) (*Client, error) {
+	if config == nil && len(opts) > 0 && opts[0] != nil {
+		config = opts[0].TLSConfig
+	}
+	return dialTimeout(newTLSClientFunc(config, address), network, address, opts...)
+}
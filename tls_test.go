@@ -0,0 +1,90 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+type Secure int
+
+// Whoami 返回客户端证书中的身份
+func (s Secure) Whoami(ctx context.Context, args int, reply *string) error {
+	p, _ := PeerFromContext(ctx)
+	*reply = p.Identity()
+	return nil
+}
+
+// testCert 用 parent 签发一张证书，parent 为 nil 时生成自签名的 CA
+func testCert(t *testing.T, cn string, parent *tls.Certificate, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := testCert(t, "test-ca", nil, x509.ExtKeyUsageAny)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := testCert(t, "server", &ca, x509.ExtKeyUsageServerAuth)
+	clientCert := testCert(t, "agent-1", &ca, x509.ExtKeyUsageClientAuth)
+
+	server := NewServer()
+	var secure Secure
+	_assert(server.Register(&secure) == nil, "register Secure")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "listen: %v", err)
+	defer func() { _ = l.Close() }()
+	go server.AcceptTLS(l, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+
+	client, err := XDial("tls@"+l.Addr().String(), &Option{
+		TLSConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{clientCert}},
+	})
+	_assert(err == nil, "dial tls: %v", err)
+	defer func() { _ = client.Close() }()
+	var who string
+	err = client.Call(context.Background(), "Secure.Whoami", 0, &who)
+	_assert(err == nil && who == "agent-1", "expect peer identity agent-1, got %q %v", who, err)
+
+	// 没有客户端证书时握手失败
+	_, err = DialTLS("tcp", l.Addr().String(), &tls.Config{RootCAs: pool}, &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "expect handshake failure without client certificate")
+}
@@ -3,6 +3,7 @@ package test
 import (
 	"context"
 	"distributed/xclient"
+	"errors"
 	"io"
 	"reflect"
 	"sync"
@@ -38,7 +39,10 @@ func (xc *XClient) dial(rpcAddr string) (*Client, error) {
 	defer xc.mu.Unlock()
 	client, ok := xc.clients[rpcAddr]
 	if ok && !client.IsAvailable() {
-		_ = client.Close()
+		// a draining client closes itself once its pending calls are done
+		if !client.isDraining() {
+			_ = client.Close()
+		}
 		delete(xc.clients, rpcAddr)
 		client = nil
 	}
@@ -61,12 +65,71 @@ func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod strin
 	return client.Call(ctx, serviceMethod, args, reply)
 }
 
+// maxShutdownRetries bounds how many times Call picks another server
+// after the selected one refused the call because it is shutting down.
+const maxShutdownRetries = 3
+
 func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	for i := 0; ; i++ {
+		rpcAddr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return err
+		}
+		err = xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+		// the call was not executed, so it is safe to retry
+		if !errors.Is(err, ErrServerShutdown) || i == maxShutdownRetries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// OneWay sends a one-way call to a server chosen by the select mode.
+func (xc *XClient) OneWay(ctx context.Context, serviceMethod string, args interface{}) error {
+	for i := 0; ; i++ {
+		rpcAddr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return err
+		}
+		client, err := xc.dial(rpcAddr)
+		if err == nil {
+			err = client.OneWay(ctx, serviceMethod, args)
+		}
+		// the request was not sent, so it is safe to pick another server
+		if !errors.Is(err, ErrServerShutdown) || i == maxShutdownRetries {
+			return err
+		}
+	}
+}
+
+// Batch sends the calls as one batch to a server chosen by the select mode.
+func (xc *XClient) Batch(ctx context.Context, calls []*Call) error {
+	for i := 0; ; i++ {
+		rpcAddr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return err
+		}
+		client, err := xc.dial(rpcAddr)
+		if err == nil {
+			err = client.Batch(ctx, calls)
+		}
+		// the batch was not executed, so it is safe to retry
+		if !errors.Is(err, ErrServerShutdown) || i == maxShutdownRetries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// NewStream starts a server-streaming call on a server chosen by the select mode.
+func (xc *XClient) NewStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
 	rpcAddr, err := xc.d.Get(xc.mode)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	client, err := xc.dial(rpcAddr)
+	if err != nil {
+		return nil, err
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	return client.NewStream(ctx, serviceMethod, args, reply)
 }
 
 // Broadcast invokes the named function for every server registered in discovery
@@ -80,6 +143,7 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var e error
 	replyDone := reply == nil // if reply is nil, don't need to set value
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {